@@ -0,0 +1,128 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+)
+
+// From ns_server and the other logs, where hosts and buckets appear...
+//
+//	Moving vbucket 512 from 'ns_1@cb1.local' to 'ns_1@cb2.local'
+//	Starting vbucket move for bucket "default", vbucket 123
+//	Trying with http://cb1.local:8091/pools/default
+var re_anon_host = regexp.MustCompile(
+	`(ns_\d+@|n_\d+@|https?://)([A-Za-z][A-Za-z0-9-]*(?:\.[A-Za-z0-9-]+)*)`)
+
+var re_anon_bucket = regexp.MustCompile(
+	"(?i)(\\bbucket(?:\\s*[:=]\\s*|\\s+)[\"'`]?)([A-Za-z0-9%._-]+)")
+
+var re_anon_digits = regexp.MustCompile(`^\s*[0-9_]+\s*$`)
+
+// An anonymizer replaces the hosts, the IP addresses, the uuids and
+// the bucket names of the entries with pseudonyms, from a keyed hash,
+// so the same identifier has the same pseudonym across the whole run,
+// preserving correlation, unlike redaction.
+type anonymizer struct {
+	key []byte
+}
+
+// newAnonymizer returns an anonymizer keyed by a salt, or by a random
+// key when the salt is "", so that the pseudonyms of different shares
+// don't cross-correlate unless they use the same salt.
+func newAnonymizer(salt string) (*anonymizer, error) {
+	key := []byte(salt)
+	if salt == "" {
+		key = make([]byte, 16)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return &anonymizer{key: key}, nil
+}
+
+// pseudonym returns the pseudonym of an identifier, like "ip-1a2b3c4d",
+// where the kind, like "ip", keeps different kinds of identifiers with
+// the same text from having the same pseudonym.
+func (a *anonymizer) pseudonym(kind string, id []byte) []byte {
+	h := hmac.New(sha256.New, a.key)
+	h.Write([]byte(kind))
+	h.Write([]byte{0})
+	h.Write(id)
+
+	return []byte(fmt.Sprintf("%s-%x", kind, h.Sum(nil)[0:4]))
+}
+
+// anonymize returns s with its identifiers replaced by pseudonyms,
+// where the hosts are replaced before the IP addresses, so that the
+// pseudonyms aren't themselves pseudonymized.
+func (a *anonymizer) anonymize(s string) string {
+	b := []byte(s)
+
+	b = re_anon_host.ReplaceAllFunc(b, func(m []byte) []byte {
+		sm := re_anon_host.FindSubmatchIndex(m)
+		return append(append([]byte(nil), m[0:sm[3]]...),
+			a.pseudonym("host", m[sm[4]:sm[5]])...)
+	})
+
+	b = re_addr.ReplaceAllFunc(b, func(m []byte) []byte {
+		sm := re_addr.FindSubmatchIndex(m)
+		if sm[2] < 0 {
+			return a.pseudonym("ip", m)
+		}
+		return append(append([]byte(nil), m[0:sm[3]]...),
+			a.pseudonym("ip", m[sm[3]:])...)
+	})
+
+	b = re_uuid.ReplaceAllFunc(b, func(m []byte) []byte {
+		if re_anon_digits.Match(m) {
+			return m // Just a number.
+		}
+		return append(append([]byte{' '}, a.pseudonym("uuid", m[1:len(m)-1])...), ' ')
+	})
+
+	b = re_anon_bucket.ReplaceAllFunc(b, func(m []byte) []byte {
+		sm := re_anon_bucket.FindSubmatchIndex(m)
+		return append(append([]byte(nil), m[0:sm[3]]...),
+			a.pseudonym("bucket", m[sm[4]:sm[5]])...)
+	})
+
+	return string(b)
+}
+
+// anonymize returns s with its identifiers pseudonymized, when the
+// Anonymize param is true, or else returns s unchanged.
+func (run *Run) anonymize(s string) string {
+	if run.anonymizer == nil {
+		return s
+	}
+	return run.anonymizer.anonymize(s)
+}
+
+// anonymizeLines returns a copy of the lines, whose identifiers are
+// pseudonymized, when the Anonymize param is true.
+func (run *Run) anonymizeLines(lines []string) []string {
+	if run.anonymizer == nil {
+		return lines
+	}
+
+	rv := make([]string, len(lines))
+	for i, line := range lines {
+		rv[i] = run.anonymizer.anonymize(line)
+	}
+	return rv
+}
@@ -0,0 +1,107 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// BenchmarkProcessEntry measures the processEntry of a FileMeta, from
+// the cleansers through the tokenizer's emitTokLits, on the entries of
+// a representative fixture in testdata/bench, where an op is an entry,
+// so the allocs/op are the allocations per entry.
+func BenchmarkProcessEntry(b *testing.B) {
+	fileInfos, err := ioutil.ReadDir("testdata/bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, fileInfo := range fileInfos {
+		fname := fileInfo.Name()
+
+		b.Run(fname, func(b *testing.B) {
+			benchProcessEntry(b, fname)
+		})
+	}
+}
+
+func benchProcessEntry(b *testing.B, fname string) {
+	run, _ := parseArgsToRun([]string{"mortimint", "-emitParts", "FULL,VALS",
+		"-emitTypes", "INT,STRING", "testdata/bench"})
+	run.addEmitter(run.EmitParts, run.EmitTypes, run.EmitFormat, ioutil.Discard)
+
+	fmeta, exists := lookupFileMeta(fname)
+	if !exists {
+		b.Fatalf("no FileMeta for: %s", fname)
+	}
+
+	run.fileProgress["bench"] = map[string]int64{}
+
+	p := run.newFileProcessor("testdata/bench", "bench", fname, fmeta)
+
+	entries := benchEntries(b, p)
+
+	var lines []string
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		e := entries[i%len(entries)]
+
+		// The processEntry owns the lines, which it might rewrite, so
+		// it gets a copy, in a reused slice, like from the scanner.
+		lines = append(lines[0:0], e.lines...)
+
+		p.processEntry(e.startOffset, e.startLine, lines)
+	}
+
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "entries/s")
+}
+
+// benchEntries splits the lines of a fixture into entries, like the
+// scanner of process() does for a file without quoted continuations.
+func benchEntries(b *testing.B, p *fileProcessor) []bufferedEntry {
+	data, err := ioutil.ReadFile("testdata/bench/" + p.fname)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var entries []bufferedEntry
+	var offset int64
+
+	for i, line := range strings.SplitAfter(string(data), "\n") {
+		lineLen := int64(len(line))
+		line = strings.TrimRight(line, "\n")
+
+		if i >= p.fmeta.HeaderSize && line != "" {
+			if len(entries) <= 0 ||
+				((p.fmeta.EntryStart == nil || p.fmeta.EntryStart(line)) &&
+					(p.fmeta.EntryRE == nil || p.fmeta.matchesEntry(line))) {
+				entries = append(entries, bufferedEntry{offset, int64(i + 1), nil})
+			}
+
+			e := &entries[len(entries)-1]
+			e.lines = append(e.lines, line)
+		}
+
+		offset += lineLen
+	}
+
+	if len(entries) <= 0 {
+		b.Fatalf("no entries in fixture: %s", p.fname)
+	}
+
+	return entries
+}
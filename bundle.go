@@ -0,0 +1,120 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// isBundle returns true when s is the path of a cbcollect_info tarball,
+// like "cbcollect_info-20160414-231044.tar.gz", rather than of a dir.
+func isBundle(s string) bool {
+	return !isURL(s) &&
+		(strings.HasSuffix(s, ".tar.gz") || strings.HasSuffix(s, ".tgz"))
+}
+
+// bundleDirBaseFName returns the dirBase and file name of a file in a
+// bundle, where the dirBase is the file's parent dir in the tarball,
+// like the per-node "cbcollect_info_ns_1@10.0.0.3_20160414-231044"
+// dir, or else the bundle's own name, when the file is at the top.
+func bundleDirBaseFName(bundle, name string) (string, string) {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+
+	dir := path.Dir(name)
+	if dir == "." {
+		dir = strings.TrimSuffix(strings.TrimSuffix(path.Base(bundle), ".tgz"), ".tar.gz")
+	}
+
+	return path.Base(dir), path.Base(name)
+}
+
+// walkBundle streams the regular files of a bundle, without extracting
+// them, invoking the visit func with every file's dirBase, file name
+// and tar header, where the visit func may read the file's content
+// from the tar.Reader until the visit func returns.
+func walkBundle(bundle string,
+	visit func(dirBase, fname string, hdr *tar.Header, tr *tar.Reader) error) error {
+	f, err := os.Open(bundle)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("error: bundle: %s, err: %v", bundle, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error: bundle: %s, err: %v", bundle, err)
+		}
+
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue // Skip the dirs, links and the like.
+		}
+
+		dirBase, fname := bundleDirBaseFName(bundle, hdr.Name)
+
+		err = visit(dirBase, fname, hdr, tr)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// processBundle processes the selected files of a bundle, one after
+// another as the tarball is streamed, where every file's processed
+// fileProcessor is sent to the doneCh, like from the workers of the
+// processDirs.
+func (run *Run) processBundle(bundle string, doneCh chan *fileProcessor) error {
+	return walkBundle(bundle, func(dirBase, fname string,
+		hdr *tar.Header, tr *tar.Reader) error {
+		fmeta, exists := run.selectFile(dirBase, fname)
+		if !exists || !run.selectFileSize(hdr.Size) {
+			return nil
+		}
+
+		run.m.Lock()
+		if run.fileProgress[dirBase] == nil {
+			run.fileProgress[dirBase] = map[string]int64{}
+		}
+		run.m.Unlock()
+
+		if run.fileProcessors[dirBase] == nil {
+			run.fileProcessors[dirBase] = map[string]*fileProcessor{}
+		}
+
+		fp := run.newFileProcessor(bundle+"/"+path.Dir(hdr.Name), dirBase, fname, fmeta)
+		fp.bundled = tr
+		fp.mtime = hdr.ModTime
+
+		run.fileProcessors[dirBase][fname] = fp
+
+		run.processFile(fp, doneCh)
+
+		return nil
+	})
+}
@@ -0,0 +1,152 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// CompareMaxVals is the max number of added or removed values that
+// are listed per changed name by the Compare param.
+var CompareMaxVals = 10
+
+// compareDictEntry is the part of an emitted DictEntry that's diffed.
+type compareDictEntry struct {
+	Kind string
+	Seen uint64
+	Vals map[string]uint64
+}
+
+// compareDicts loads the two JSON dictionaries of the Compare param,
+// as emitted by EmitDict from two runs, like from two builds, and
+// writes to w the names that were added, removed or changed, where a
+// name changes when its kind or its set of values changes.
+func (run *Run) compareDicts(w io.Writer) {
+	paths := strings.Split(run.Compare, ",")
+	if len(paths) != 2 {
+		log.Fatalf("error: compare needs two comma-separated dict paths, got: %q",
+			run.Compare)
+	}
+
+	a, b := loadCompareDict(paths[0]), loadCompareDict(paths[1])
+
+	names := make([]string, 0, len(a)+len(b))
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, exists := a[name]; !exists {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var added, removed, changed int
+
+	for _, name := range names {
+		deA, inA := a[name]
+		deB, inB := b[name]
+
+		if !inA {
+			fmt.Fprintf(w, "added   %s %s seen: %d\n", name, deB.Kind, deB.Seen)
+			added++
+			continue
+		}
+
+		if !inB {
+			fmt.Fprintf(w, "removed %s %s seen: %d\n", name, deA.Kind, deA.Seen)
+			removed++
+			continue
+		}
+
+		if deA.Kind != deB.Kind {
+			fmt.Fprintf(w, "changed %s kind: %s -> %s\n", name, deA.Kind, deB.Kind)
+			changed++
+			continue
+		}
+
+		valsAdded, valsRemoved := diffVals(deA.Vals, deB.Vals)
+		if len(valsAdded) > 0 || len(valsRemoved) > 0 {
+			fmt.Fprintf(w, "changed %s %s vals added: %s, removed: %s\n",
+				name, deA.Kind, compareVals(valsAdded), compareVals(valsRemoved))
+			changed++
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "compare: %s -> %s, added: %d, removed: %d, changed: %d\n",
+		paths[0], paths[1], added, removed, changed)
+}
+
+// loadCompareDict loads the Dict of a JSON dictionary file.
+func loadCompareDict(path string) map[string]compareDictEntry {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("error: compare: %v", err)
+	}
+	defer f.Close()
+
+	var d struct {
+		Dict map[string]compareDictEntry
+	}
+
+	err = json.NewDecoder(f).Decode(&d)
+	if err != nil {
+		log.Fatalf("error: compare, path: %s, err: %v", path, err)
+	}
+
+	return d.Dict
+}
+
+// diffVals returns the sorted values that are only in b, and only in a.
+func diffVals(a, b map[string]uint64) (added, removed []string) {
+	for v := range b {
+		if _, exists := a[v]; !exists {
+			added = append(added, v)
+		}
+	}
+	for v := range a {
+		if _, exists := b[v]; !exists {
+			removed = append(removed, v)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return added, removed
+}
+
+// compareVals formats values as a JSON array, capped at CompareMaxVals,
+// with the count of any values that were left out.
+func compareVals(vals []string) string {
+	more := len(vals) - CompareMaxVals
+	if more > 0 {
+		vals = vals[0:CompareMaxVals]
+	}
+
+	j, _ := json.Marshal(vals)
+	if vals == nil {
+		j = []byte("[]")
+	}
+
+	if more > 0 {
+		return fmt.Sprintf("%s (+%d more)", j, more)
+	}
+
+	return string(j)
+}
@@ -0,0 +1,101 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// processEmitCooccur writes the co-occurrence report of the names of
+// the run's dict, as a CSV matrix when the EmitCooccur path has a
+// ".csv" suffix, or else as JSON, like {"bucket": {"vbucket": 12}}.
+func (run *Run) processEmitCooccur() {
+	if run.EmitCooccur == "" {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "emitting co-occurrence report: %s\n", run.EmitCooccur)
+
+	f, err := os.OpenFile(run.EmitCooccur, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(run.EmitCooccur, ".csv") {
+		err = writeCooccurCSV(f, run.dict)
+	} else {
+		err = writeCooccurJSON(f, run.dict)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// cooccurNames returns the sorted names of a dict that co-occur with
+// at least one other name.
+func cooccurNames(dict Dict) []string {
+	var names []string
+	for name, de := range dict {
+		if len(de.Cooccur) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeCooccurCSV writes the co-occurrence matrix as CSV, whose first
+// row and first column are the names, and whose cells are the counts
+// of the entries where the row's and the column's names co-occur.
+func writeCooccurCSV(w io.Writer, dict Dict) error {
+	names := cooccurNames(dict)
+
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(append([]string{""}, names...)); err != nil {
+		return err
+	}
+
+	for _, a := range names {
+		row := append(make([]string, 0, len(names)+1), a)
+		for _, b := range names {
+			row = append(row, strconv.FormatUint(dict[a].Cooccur[b], 10))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// writeCooccurJSON writes the co-occurrence matrix as JSON, keyed by
+// name, then by the other name, where zero counts are omitted.
+func writeCooccurJSON(w io.Writer, dict Dict) error {
+	m := map[string]map[string]uint64{}
+	for _, name := range cooccurNames(dict) {
+		m[name] = dict[name].Cooccur
+	}
+
+	return json.NewEncoder(w).Encode(m)
+}
@@ -17,13 +17,22 @@ func MakeHistogram() *ghistogram.Histogram {
 type Dict map[string]*DictEntry
 
 type DictEntry struct {
-	Kind string // For exmaple, "INT" or "STRING".
+	Kind string // For exmaple, "INT", "STRING" or "BOOL".
 	Seen uint64 // Count of number of times this entry was seen.
 
-	// When the Kind is "STRING", sub-dictionary of value counts.
+	// When the Kind is "STRING" or "BOOL", sub-dictionary of value counts.
 	Vals map[string]uint64 `json:"Vals,omitempty"`
 
 	IntHistogram *ghistogram.Histogram `json:"IntHistogram,omitempty"`
+
+	// Distinct value literals, in the order first seen, capped by the
+	// maxSamples of AddDictEntry, which helps tell high-cardinality
+	// names (ids, timestamps) apart from low-cardinality enums.
+	Samples []string `json:"Samples,omitempty"`
+
+	// Counts of the entries where this name co-occurs with another
+	// name, keyed by the other name, as tracked by AddCooccur.
+	Cooccur map[string]uint64 `json:"Cooccur,omitempty"`
 }
 
 func MakeDictEntry(kind string) *DictEntry {
@@ -34,30 +43,67 @@ func MakeDictEntry(kind string) *DictEntry {
 	}
 }
 
-func (dict Dict) AddDictEntry(kind string, name, val string) {
+// AddDictEntry counts a value of a name, where up to maxSamples
+// distinct value literals are kept as samples, and returns false when
+// the name is new but the dict already has maxNames names, if > 0.
+func (dict Dict) AddDictEntry(kind string, name, val string,
+	maxSamples, maxNames int) bool {
 	de := dict[name]
 	if de == nil {
+		if maxNames > 0 && len(dict) >= maxNames {
+			return false
+		}
+
 		de = MakeDictEntry(kind)
 		dict[name] = de
 	}
 
 	de.Seen++
 
-	if kind == "STRING" {
+	if kind == "STRING" || kind == "BOOL" {
 		de.Vals[val]++
 	}
 
+	de.AddSample(val, maxSamples)
+
 	v, err := strconv.ParseInt(val, 10, 64)
 	if err == nil && v >= 0 {
 		de.IntHistogram.Add(uint64(v), 1)
 	}
+
+	return true
+}
+
+// AddSample adds val to the Samples, unless it's empty, already
+// there, or there are already maxSamples samples.
+func (de *DictEntry) AddSample(val string, maxSamples int) {
+	if val == "" || len(de.Samples) >= maxSamples {
+		return
+	}
+
+	for _, sample := range de.Samples {
+		if sample == val {
+			return
+		}
+	}
+
+	de.Samples = append(de.Samples, val)
 }
 
-// AddTo adds the entries from src to dst.
-func (src Dict) AddTo(dst Dict) {
+// AddTo adds the entries from src to dst, keeping up to maxSamples
+// distinct samples, and returns false when names were dropped as dst
+// already has maxNames names, if > 0.
+func (src Dict) AddTo(dst Dict, maxSamples, maxNames int) bool {
+	added := true
+
 	for name, srcDE := range src {
 		dstDE := dst[name]
 		if dstDE == nil {
+			if maxNames > 0 && len(dst) >= maxNames {
+				added = false
+				continue
+			}
+
 			dstDE = MakeDictEntry(srcDE.Kind)
 			dst[name] = dstDE
 		}
@@ -67,5 +113,39 @@ func (src Dict) AddTo(dst Dict) {
 			dstDE.Vals[v] += vi
 		}
 		dstDE.IntHistogram.AddAll(srcDE.IntHistogram)
+		for _, sample := range srcDE.Samples {
+			dstDE.AddSample(sample, maxSamples)
+		}
+		for other, n := range srcDE.Cooccur {
+			if dstDE.Cooccur == nil {
+				dstDE.Cooccur = map[string]uint64{}
+			}
+			dstDE.Cooccur[other] += n
+		}
+	}
+
+	return added
+}
+
+// AddCooccur counts the co-occurrences of every pair of the distinct
+// names of an entry, where names that aren't in the dict are ignored.
+func (dict Dict) AddCooccur(names []string) {
+	distinct := make(map[string]bool, len(names))
+	for _, name := range names {
+		if dict[name] != nil {
+			distinct[name] = true
+		}
+	}
+
+	for a := range distinct {
+		de := dict[a]
+		for b := range distinct {
+			if a != b {
+				if de.Cooccur == nil {
+					de.Cooccur = map[string]uint64{}
+				}
+				de.Cooccur[b]++
+			}
+		}
 	}
 }
@@ -1,55 +1,270 @@
 package main
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 )
 
 type Emitter struct {
 	emitParts map[string]bool // True when that part should be emitted.
 	emitTypes map[string]bool // True when that value type should be emitted.
 
+	format string // Output format, like "" (the default), "aligned" or "json".
+
+	jsonEnc *json.Encoder // Used by the "json" format.
+
+	// When non-"", the name path is emitted joined by this separator,
+	// like "a.b", instead of like "[a b]".
+	pathSeparator string
+
+	alignWidth int // Width of the module column in the "aligned" format.
+
 	w io.Writer
 }
 
 func (run *Run) addEmitterFile(outDir, outName, parts, types string) (
 	string, io.Closer) {
 	outPath := outDir + string(os.PathSeparator) + outName
+	if run.GzipOut {
+		outPath += ".gz"
+	}
+
 	outFile, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	run.addEmitter(parts, types, outFile)
+	var w io.WriteCloser = outFile
+	if run.GzipOut {
+		w = &gzipFile{Writer: gzip.NewWriter(outFile), f: outFile}
+	}
+
+	run.addEmitter(parts, types, "", w)
+
+	// The emitted files keep the name paths in their "[a b]" form, as
+	// that's what the web server's graphData parses from the vals.log.
+	run.emitters[len(run.emitters)-1].pathSeparator = ""
+
+	return outPath, w
+}
+
+// gzipFile is an output file whose writes are gzip compressed.
+type gzipFile struct {
+	*gzip.Writer
+	f *os.File
+}
+
+// Close flushes the gzip stream and closes the file.
+func (g *gzipFile) Close() error {
+	err := g.Writer.Close()
+	if errF := g.f.Close(); err == nil {
+		err = errF
+	}
+	return err
+}
+
+// closeOnSignal closes the emitted files when the process is
+// interrupted or terminated, so that any gzip'ed output files are
+// flushed into complete, readable streams, and then exits.
+func (run *Run) closeOnSignal(emittedFiles map[string]io.Closer) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	sig := <-sigCh
+
+	run.m.Lock() // Wait for any in-flight emit to finish.
+	for _, f := range emittedFiles {
+		f.Close()
+	}
+
+	fmt.Fprintf(os.Stderr, "\n%v, closed emitted files\n", sig)
+
+	os.Exit(1)
+}
+
+func (run *Run) addEmitter(parts, types, format string, w io.Writer) {
+	e := &Emitter{
+		emitParts:  csvToMap(parts, map[string]bool{}),
+		emitTypes:  csvToMap(types, map[string]bool{}),
+		format:     format,
+		alignWidth: run.EmitAlignWidth,
+
+		pathSeparator: run.PathSeparator,
+		w:             &countWriter{w: w, n: &run.emitBytes},
+	}
+
+	if format == "json" {
+		// An Encode is a single Write, so the emitted objects, which
+		// are written while holding the run.m, aren't interleaved.
+		e.jsonEnc = json.NewEncoder(e.w)
+		e.jsonEnc.SetEscapeHTML(false)
+		if run.PrettyJSON {
+			e.jsonEnc.SetIndent("", "  ")
+		}
+	}
+
+	run.emitters = append(run.emitters, e)
+}
+
+// countWriter counts the bytes written, where the caller must hold
+// any lock that protects n.
+type countWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	*c.n += int64(n)
+	return n, err
+}
+
+// alignLevelWidth is the width of the level column of the "aligned"
+// format, which fits the normalized levels, like "DEBUG" or "WARN".
+const alignLevelWidth = 5
+
+// align pads the ts, level and module columns to their widths, so every
+// line's columns line up, from the first line onwards, where the ts is
+// as wide as the tsLayout, and the module is padded to the alignWidth,
+// when it's > 0. A longer value isn't truncated, but shifts the line.
+func (e *Emitter) align(ts, level, module string) (string, string, string) {
+	return pad(ts, len(tsLayout)), pad(level, alignLevelWidth), pad(module, e.alignWidth)
+}
+
+// pad returns s padded with spaces to the width.
+func pad(s string, width int) string {
+	if len(s) >= width {
+		return s
+	}
+
+	return s + strings.Repeat(" ", width-len(s))
+}
+
+// A jsonRecord is an object of the "json" format, whose keys are all
+// always emitted, in this order, where the Tok is the value type.
+type jsonRecord struct {
+	TS     string   `json:"ts"`
+	Module string   `json:"module"`
+	Level  string   `json:"level"`
+	Dir    string   `json:"dir"`
+	FName  string   `json:"fname"`
+	OL     string   `json:"ol"`
+	Offset int64    `json:"offset"`
+	Line   int64    `json:"line"`
+	Kind   string   `json:"kind"`
+	Path   []string `json:"path"`
+	Name   string   `json:"name"`
+	Tok    string   `json:"tok"`
+	Val    string   `json:"val"`
 
-	return outPath, outFile
+	Truncated bool `json:"truncated,omitempty"`
+
+	Confidence json.Number `json:"confidence,omitempty"` // Of a FULL record.
 }
 
-func (run *Run) addEmitter(parts, types string, w io.Writer) {
-	run.emitters = append(run.emitters, &Emitter{
-		emitParts: csvToMap(parts, map[string]bool{}),
-		emitTypes: csvToMap(types, map[string]bool{}),
-		w:         w,
-	})
+// emitJSON writes a record as a JSON object, which is a single line
+// unless the PrettyJSON param is true.
+func (e *Emitter) emitJSON(r *jsonRecord) {
+	if r.Path == nil {
+		r.Path = []string{} // A JSON array, rather than null.
+	}
+
+	err := e.jsonEnc.Encode(r)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
-func (e *Emitter) emitEntryFull(ts, module, level, fnameOut, ol, linesJoined string) {
+func (e *Emitter) emitEntryFull(ts, module, level, dirBase, fname, fnameOut, ol string,
+	startOffset, startLine int64, linesJoined string, truncated bool, confidence string) {
+	if e.jsonEnc != nil {
+		e.emitJSON(&jsonRecord{TS: ts, Module: module, Level: level,
+			Dir: dirBase, FName: fname, OL: strings.TrimSpace(ol),
+			Offset: startOffset, Line: startLine, Kind: "FULL",
+			Val: linesJoined, Truncated: truncated, Confidence: json.Number(confidence)})
+		return
+	}
+
+	if e.format == "aligned" {
+		ts, level, module = e.align(ts, level, module)
+	}
+
 	partKind := ""
 	if len(e.emitParts) > 1 {
 		partKind = "FULL "
 	}
 
-	fmt.Fprintf(e.w, "  %s %s %s %s %s%s ",
-		ts, level, fnameOut, ol, partKind, module)
-	fmt.Fprintln(e.w, linesJoined)
+	var suffix string
+	if truncated {
+		suffix = " truncated=true"
+	}
+	if confidence != "" {
+		suffix += " confidence=" + confidence
+	}
+
+	fmt.Fprintf(e.w, "  %s %s %s %s %s%s %s%s\n",
+		ts, level, fnameOut, ol, partKind, module, linesJoined, suffix)
 }
 
-func (e *Emitter) emitEntryPart(ts, module, level, fnameOut, ol, partKind string,
-	namePath []string, name, valType, val string, valQuoted bool) {
+func (e *Emitter) emitFileRecord(ts, module, dirBase, fname, fnameOut, ol, fields string) {
+	if !e.emitParts["FILE"] {
+		return
+	}
+
+	if e.jsonEnc != nil {
+		e.emitJSON(&jsonRecord{TS: ts, Module: module, Level: "FILE",
+			Dir: dirBase, FName: fname, OL: strings.TrimSpace(ol), Kind: "FILE", Val: fields})
+		return
+	}
+
+	level := "FILE"
+	if e.format == "aligned" {
+		ts, level, module = e.align(ts, level, module)
+	}
+
+	partKind := ""
+	if len(e.emitParts) > 1 {
+		partKind = "FILE "
+	}
+
+	fmt.Fprintf(e.w, "  %s %s %s %s %s%s %s\n",
+		ts, level, fnameOut, ol, partKind, module, fields)
+}
+
+func (e *Emitter) emitEntryPart(ts, module, level, dirBase, fname, fnameOut, ol string,
+	startOffset, startLine int64, partKind string,
+	namePath []string, name, valType, val string, valQuoted, truncated bool) {
 	if e.emitParts[partKind] && e.emitTypes[valType] {
+		if e.jsonEnc != nil {
+			// A STRING from the tokenizer is a go literal, like `"a"`,
+			// unlike a valQuoted STRING, so it's unquoted for the JSON
+			// encoding, unless it was truncated.
+			if valType == "STRING" && !valQuoted {
+				if unquoted, err := strconv.Unquote(val); err == nil {
+					val = unquoted
+				}
+			}
+
+			e.emitJSON(&jsonRecord{TS: ts, Module: module, Level: level,
+				Dir: dirBase, FName: fname, OL: strings.TrimSpace(ol),
+				Offset: startOffset, Line: startLine, Kind: partKind,
+				Path: namePath, Name: name, Tok: valType, Val: val,
+				Truncated: truncated})
+			return
+		}
+
+		if e.format == "aligned" {
+			ts, level, module = e.align(ts, level, module)
+		}
+
 		if len(e.emitParts) <= 1 {
 			partKind = ""
 		} else if partKind != "" {
@@ -60,14 +275,24 @@ func (e *Emitter) emitEntryPart(ts, module, level, fnameOut, ol, partKind string
 			name = name + " "
 		}
 
+		var path interface{} = namePath
+		if e.pathSeparator != "" {
+			path = strings.Join(namePath, e.pathSeparator)
+		}
+
+		suffix := ""
+		if truncated {
+			suffix = " truncated=true"
+		}
+
 		if valQuoted {
-			fmt.Fprintf(e.w, "  %s %s %s %s %s%s %+v %s= %s %q\n",
+			fmt.Fprintf(e.w, "  %s %s %s %s %s%s %+v %s= %s %q%s\n",
 				ts, level, fnameOut, ol, partKind, module,
-				namePath, name, valType, val)
+				path, name, valType, val, suffix)
 		} else {
-			fmt.Fprintf(e.w, "  %s %s %s %s %s%s %+v %s= %s %s\n",
+			fmt.Fprintf(e.w, "  %s %s %s %s %s%s %+v %s= %s %s%s\n",
 				ts, level, fnameOut, ol, partKind, module,
-				namePath, name, valType, val)
+				path, name, valType, val, suffix)
 		}
 	}
 }
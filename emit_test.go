@@ -0,0 +1,106 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAlignFixedWidth(t *testing.T) {
+	e := &Emitter{alignWidth: 10}
+
+	for _, c := range [][6]string{
+		{"", "", "",
+			"                       ", "     ", "          "},
+		{"2016-04-14T16:10:05.262", "INFO", "memcached",
+			"2016-04-14T16:10:05.262", "INFO ", "memcached "},
+		{"0000-00-00T00:00:00.000", "DEBUG", "ns_server.couchdb",
+			"0000-00-00T00:00:00.000", "DEBUG", "ns_server.couchdb"},
+	} {
+		ts, level, module := e.align(c[0], c[1], c[2])
+		if ts != c[3] {
+			t.Errorf("align ts: %q, expected: %q", ts, c[3])
+		}
+		if level != c[4] {
+			t.Errorf("align level: %q, expected: %q", level, c[4])
+		}
+		if module != c[5] {
+			t.Errorf("align module: %q, expected: %q", module, c[5])
+		}
+	}
+
+	// Without an alignWidth, only the module isn't padded.
+	e.alignWidth = 0
+	if ts, level, module := e.align("", "INFO", "ns_server"); len(ts) != len(tsLayout) ||
+		level != "INFO " || module != "ns_server" {
+		t.Errorf("align without a width: %q, %q, %q", ts, level, module)
+	}
+}
+
+func TestConfidenceOnFull(t *testing.T) {
+	out := runFixture(t, "-confidence", "-onlyModules", "master_events",
+		"testdata/parseerrors")
+
+	// The entry without a timestamp is less certain.
+	expectLines(t, out,
+		`2016-04-14T23:10:06.262 INFO parseerrors/master_events.log 0:1 master_events {"type":"rebalanceStart","ts":1460675406.262} confidence=1.00`,
+		`0000-00-00T00:00:00.000 INFO parseerrors/master_events.log 94:4 master_events {"type":"vbucketMoveDone"} confidence=0.75`)
+
+	out = runFixture(t, "-confidence", "-emitFormat", "json", "-emitParts", "FULL,VALS",
+		"-emitTypes", "INT,STRING,FLOAT", "-onlyModules", "master_events", "testdata/parseerrors")
+	if strings.Count(out, `"kind":"FULL"`) != 2 || strings.Count(out, `"confidence":`) != 2 ||
+		!strings.Contains(out, `"confidence":0.75}`) || strings.Contains(out, `"name":"confidence"`) {
+		t.Errorf("expected the confidence of only the FULL records, got:\n%s", out)
+	}
+}
+
+func TestJSONUnquotesStrings(t *testing.T) {
+	out := runFixture(t, "-emitParts", "VALS", "-emitTypes", "STRING",
+		"-emitFormat", "json", "-onlyModules", "error_logger", "testdata/bench")
+
+	// The tokenizer's "<0.6.0>" literal and the extractor's unquoted
+	// initial_call are both emitted without their go quotes.
+	for _, exp := range []string{
+		`"name":"error_logger","tok":"STRING","val":"<0.6.0>"`,
+		`"name":"initial_call","tok":"STRING","val":"ns_janitor:init/1"`,
+	} {
+		if !strings.Contains(out, exp) {
+			t.Errorf("expected: %s, got:\n%s", exp, out)
+		}
+	}
+}
+
+func TestAnonymizeFName(t *testing.T) {
+	run, _ := parseArgsToRun([]string{"mortimint", "-anonymize", "-anonymizeSalt", "s"})
+
+	fp := run.newFileProcessor("x", "ns_1@10.0.0.3", "10.0.0.3.log", FileMetaNS)
+	for _, s := range []string{fp.dirBaseAnon, fp.fnameAnon, fp.fnameOut} {
+		if strings.Contains(s, "10.0.0.3") {
+			t.Errorf("expected pseudonyms, got: %q", s)
+		}
+	}
+
+	if fp.dirBase != "ns_1@10.0.0.3" || fp.fname != "10.0.0.3.log" {
+		t.Errorf("expected the original keys, got: %q, %q", fp.dirBase, fp.fname)
+	}
+}
+
+func TestPathSeparator(t *testing.T) {
+	out := runFixture(t, "-emitParts", "VALS", "testdata/nested")
+	expectLines(t, out,
+		"2016-04-14T16:10:10.000 NOTI nested/memcached.log 12:5 memcached cfg.a.b.c d = INT 1")
+
+	out = runFixture(t, "-emitParts", "VALS", "-pathSeparator=", "testdata/nested")
+	expectLines(t, out,
+		"2016-04-14T16:10:10.000 NOTI nested/memcached.log 12:5 memcached [cfg a b c] d = INT 1")
+}
@@ -0,0 +1,102 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+// Entry is a structured log entry, as passed to the EntryCallback of
+// a Run by programs that embed mortimint's parsing, instead of (or
+// in addition to) having the emitters write text.
+type Entry struct {
+	TS     string // Like "2016-04-14T16:10:05.262".
+	Level  string // Like "INFO".
+	Module string // Like "ns_server".
+	Dir    string // Like "cbcollect_n1", pseudonymized with the Anonymize param.
+	File   string // Like "ns_server.info.log", pseudonymized with the Anonymize param.
+	Offset int64  // Byte offset of the entry's first line.
+	Line   int64  // Line number of the entry's first line.
+
+	Lines []string // The lines of the entry, as emitted for FULL.
+
+	Confidence string // Like "0.85", with the Confidence param, else "".
+	Parts      []Part // The VALS, MIDS and ENDS parts of the entry.
+}
+
+// Part is a name and value that was parsed from an Entry.
+type Part struct {
+	Kind string   // Like "VALS", "MIDS" or "ENDS".
+	Path []string // Like ["memstats"], the names enclosing the Name.
+	Name string
+	Type string // Like "INT", "STRING" or "BOOL".
+	Val  string
+}
+
+// entryFullLocked starts a pending Entry for the EntryCallback, which
+// is passed to the EntryCallback once the next entry of the file
+// starts, or when the file is done, where the caller must hold run.m.
+func (run *Run) entryFullLocked(ts, module, level, dirBase, dirBaseAnon, fname, fnameAnon string,
+	startOffset, startLine int64, lines []string) {
+	run.entryFlushLocked(dirBase, fname)
+
+	run.entries[dirBase+"/"+fname] = &Entry{
+		TS:     ts,
+		Level:  level,
+		Module: module,
+		Dir:    dirBaseAnon,
+		File:   fnameAnon,
+		Offset: startOffset,
+		Line:   startLine,
+		Lines:  append([]string(nil), lines...), // The lines are reused.
+	}
+}
+
+// entryPartLocked adds a part to the pending Entry of the file, where
+// the caller must hold run.m.
+func (run *Run) entryPartLocked(ts, module, level, dirBase, dirBaseAnon, fname, fnameAnon string,
+	startOffset, startLine int64, partKind string,
+	namePath []string, name, valType, val string) {
+	entry := run.entries[dirBase+"/"+fname]
+	if entry == nil || entry.Offset != startOffset {
+		run.entryFullLocked(ts, module, level, dirBase, dirBaseAnon, fname, fnameAnon,
+			startOffset, startLine, nil)
+
+		entry = run.entries[dirBase+"/"+fname]
+	}
+
+	entry.Parts = append(entry.Parts, Part{
+		Kind: partKind,
+		Path: append([]string(nil), namePath...),
+		Name: name,
+		Type: valType,
+		Val:  val,
+	})
+}
+
+// entryFlushLocked passes the pending Entry of the file, if any, to
+// the EntryCallback, where the caller must hold run.m, so that the
+// EntryCallback is never invoked concurrently.
+func (run *Run) entryFlushLocked(dirBase, fname string) {
+	entry := run.entries[dirBase+"/"+fname]
+	if entry != nil {
+		delete(run.entries, dirBase+"/"+fname)
+
+		run.EntryCallback(*entry)
+	}
+}
+
+// entryFlush passes the last pending Entry of a processed file to
+// the EntryCallback.
+func (run *Run) entryFlush(dirBase, fname string) {
+	if run.EntryCallback != nil {
+		run.m.Lock()
+		run.entryFlushLocked(dirBase, fname)
+		run.m.Unlock()
+	}
+}
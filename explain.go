@@ -0,0 +1,114 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go/scanner"
+	"go/token"
+)
+
+// isExplained returns true when the entry at the given offset and
+// line should have its parsing traced, per the Explain param.
+func (run *Run) isExplained(startOffset, startLine int64) bool {
+	if run.Explain == "" {
+		return false
+	}
+
+	offset := strconv.FormatInt(startOffset, 10)
+
+	return run.Explain == offset ||
+		run.Explain == offset+":"+strconv.FormatInt(startLine, 10)
+}
+
+func (p *fileProcessor) explainf(format string, a ...interface{}) {
+	p.run.m.Lock()
+	fmt.Fprintf(os.Stderr, "explain %s/%s: "+format+"\n",
+		append([]interface{}{p.dirBaseAnon, p.fnameAnon}, a...)...)
+	p.run.m.Unlock()
+}
+
+func (p *fileProcessor) explainEntry(lines []string) {
+	p.explainf("meta: %s, HeaderSize: %d, EntryStart: %t, Cleanser: %t",
+		fileMetaName(p.fname), p.fmeta.HeaderSize,
+		p.fmeta.EntryStart != nil, p.fmeta.Cleanser != nil)
+	p.explainf("EntryRE: %s", p.fmeta.EntryRE)
+	p.explainf("skipTokens: %s", skipTokensString(p.run.skipTokens))
+	for _, re := range p.fmeta.EntryREs {
+		p.explainf("EntryREs: %s", re)
+	}
+
+	for i, line := range lines {
+		p.explainf("line %d: %q", i, p.run.anonymize(line))
+	}
+}
+
+func (p *fileProcessor) explainMatch(firstLine string,
+	entryRE *regexp.Regexp, matchIndex []int) {
+	if len(matchIndex) <= 0 {
+		p.explainf("EntryRE did not match, skipping entry")
+		return
+	}
+
+	if entryRE != p.fmeta.EntryRE {
+		p.explainf("EntryREs matched: %s", entryRE)
+	}
+
+	for i, name := range entryRE.SubexpNames() {
+		if i > 0 && name != "" && matchIndex[2*i] >= 0 {
+			p.explainf("EntryRE group %s: %q",
+				name, p.run.anonymize(firstLine[matchIndex[2*i]:matchIndex[2*i+1]]))
+		}
+	}
+}
+
+// explainTokens traces the token stream of the cleansed buf, using
+// a scanner that's separate from the one used for emitting.
+func (p *fileProcessor) explainTokens(buf []byte) {
+	p.explainf("cleansed: %q", buf)
+
+	var s scanner.Scanner
+
+	fset := token.NewFileSet()
+
+	s.Init(fset.AddFile(p.fname, fset.Base(), len(buf)), buf, nil, 0)
+
+	var toks []string
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+
+		if p.run.skipTokens[tok] {
+			toks = append(toks, "(skip "+tok.String()+")")
+		} else {
+			toks = append(toks, fmt.Sprintf("%s(%q)", tok, lit))
+		}
+	}
+
+	p.explainf("tokens: %s", strings.Join(toks, " "))
+}
+
+// tokDebugf traces a decision of the tokenizer, for the TokenizerDebug
+// param, along with the path and depth of the nesting level.
+func (p *fileProcessor) tokDebugf(ol string, path []string, format string, a ...interface{}) {
+	p.run.m.Lock()
+	fmt.Fprintf(os.Stderr, "tokenizer %s/%s %s depth %d %q: "+format+"\n",
+		append([]interface{}{p.dirBaseAnon, p.fnameAnon, strings.TrimSpace(ol), len(path), path}, a...)...)
+	p.run.m.Unlock()
+}
@@ -0,0 +1,688 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// An Extractor finds structured values in the cleansed buf of an
+// entry, emitting them as VALS parts via the emit callback, and
+// returns the buf with the extracted text rewritten or blanked, so
+// that the tokenizer doesn't shred the extracted text.
+type Extractor func(p *fileProcessor, buf []byte,
+	emit func(path []string, name, valType, val string)) []byte
+
+// ------------------------------------------------------------
+
+// From projector...
+//
+//	2016-04-05T13:22:26.133+01:00 [Info] pram[:9999] registered /adminport/vbmapRequest
+var re_rpc = regexp.MustCompile(`/adminport/\w+`)
+
+// extractRPC emits the adminport RPC paths of goxdcr and projector
+// as rpc VALS parts, before the tokenizer would split them on '/'.
+func extractRPC(p *fileProcessor, buf []byte,
+	emit func(path []string, name, valType, val string)) []byte {
+	for _, m := range re_rpc.FindAll(buf, -1) {
+		emit(nil, "rpc", "STRING", string(m))
+	}
+
+	return re_rpc.ReplaceAll(buf, stringify_replace)
+}
+
+// ------------------------------------------------------------
+
+// From projector, after extractRPC has quoted the path...
+//
+//	2016-04-05T13:22:26.133+01:00 [Info] pram[:9999] registered /adminport/vbmapRequest
+var re_pram = regexp.MustCompile(`pram\[\S*?:(\d+)\]\s+registered\s+"?([^"\s]+)"?`)
+
+// extractPram emits the listener port and the registered path of
+// projector's pram[:port] registrations as VALS parts, and blanks
+// them, as the tokenizer would otherwise mangle the brackets.
+func extractPram(p *fileProcessor, buf []byte,
+	emit func(path []string, name, valType, val string)) []byte {
+	for _, m := range re_pram.FindAllSubmatch(buf, -1) {
+		emit([]string{"pram"}, "port", "INT", string(m[1]))
+		emit([]string{"pram"}, "registered", "STRING", string(m[2]))
+	}
+
+	return re_pram.ReplaceAll(buf, []byte(" "))
+}
+
+// ------------------------------------------------------------
+
+// From projector, where a DCP stream's topic has a mac-like suffix...
+//
+//	2016-04-12T10:17:35.286+01:00 [Info] VBRT[<-49<-travel-sample<-127.0.0.1:8091 #MAINT_STREAM_TOPIC_bb:44:4a:7f:f5:90:d5:91] ##3b created
+//	2016-04-12T10:17:35.301+01:00 [Info] KVDT[<-travel-sample<-127.0.0.1:8091 #INIT_STREAM_TOPIC_bb:44:4a:7f:f5:90:d5:91] start-timestamp bucket: travel-sample
+var re_stream_topic = regexp.MustCompile(`#(\w*STREAM_TOPIC_[0-9A-Fa-f]{2}(?::[0-9A-Fa-f]{2})*)`)
+
+// extractStreamTopic emits projector's stream topics, like
+// "MAINT_STREAM_TOPIC_bb:44:4a:7f:f5:90:d5:91", as stream_topic VALS
+// parts, and blanks them, as the tokenizer would otherwise split the
+// topic's suffix on its ':' separators.
+func extractStreamTopic(p *fileProcessor, buf []byte,
+	emit func(path []string, name, valType, val string)) []byte {
+	for _, m := range re_stream_topic.FindAllSubmatchIndex(buf, -1) {
+		emit(nil, "stream_topic", "STRING", string(buf[m[2]:m[3]]))
+
+		for i := m[0]; i < m[1]; i++ {
+			buf[i] = ' '
+		}
+	}
+
+	return buf
+}
+
+// ------------------------------------------------------------
+
+// From ns_server.error.log...
+//
+//	[ns_server:error,2016-04-14T16:10:06.262-07:00,ns_1@127.0.0.1:<0.124.0>:...]
+//	got {error,{badrpc,nodedown}}
+//	  exception exit: {badmatch,{error,enoent}}
+var re_error_tuple = regexp.MustCompile(`\{\s*(error|badmatch)\s*,`)
+
+// extractErrorReason emits the Reason of erlang {error,Reason} and
+// {badmatch,Reason} tuples as error_reason VALS parts, and rewrites
+// the Reason into a single string so it's tokenized coherently.
+func extractErrorReason(p *fileProcessor, buf []byte,
+	emit func(path []string, name, valType, val string)) []byte {
+	for at := 0; at < len(buf); {
+		m := re_error_tuple.FindIndex(buf[at:])
+		if m == nil {
+			break
+		}
+
+		end := balancedEnd(buf, at+m[0])
+		if end < 0 {
+			break
+		}
+
+		reasonStart, reasonEnd := at+m[1], end-1 // Excludes the '}'.
+
+		reason := strings.Join(strings.Fields(string(buf[reasonStart:reasonEnd])), " ")
+		if reason == "" {
+			at = end
+			continue
+		}
+
+		emit(nil, "error_reason", "STRING", reason)
+
+		q := strconv.Quote(reason)
+
+		rewritten := make([]byte, 0, len(buf)+len(q))
+		rewritten = append(rewritten, buf[0:reasonStart]...)
+		rewritten = append(rewritten, q...)
+		rewritten = append(rewritten, buf[reasonEnd:]...)
+
+		buf = rewritten
+		at = reasonStart + len(q) + 1
+	}
+
+	return buf
+}
+
+// ------------------------------------------------------------
+
+// From stats lines, where a metric's name has a unit in parentheses...
+//
+//	cmd_get (per sec): 1423
+//	disk_write_queue (items): 12, resident_ratio (%): 98.5
+var re_unit_metric = regexp.MustCompile(
+	`(?:^|[\s,;{])([A-Za-z_][\w.]*)\s+\(([^()\n]{1,20})\)\s*:\s*(-?\d+(?:\.\d+)?)\b`)
+
+// extractUnitMetrics emits the value of a metric whose name has a unit
+// in parentheses as a VALS part of the name, as the tokenizer would
+// otherwise take the unit as a nested path, along with the unit as a
+// unit VALS part under the name's path, and blanks the metric.
+func extractUnitMetrics(p *fileProcessor, buf []byte,
+	emit func(path []string, name, valType, val string)) []byte {
+	for _, m := range re_unit_metric.FindAllSubmatchIndex(buf, -1) {
+		name := string(buf[m[2]:m[3]])
+		val := string(buf[m[6]:m[7]])
+
+		valType := "INT"
+		if strings.Contains(val, ".") {
+			valType = "FLOAT"
+		}
+
+		emit(nil, name, valType, val)
+		emit([]string{name}, "unit", "STRING", strings.TrimSpace(string(buf[m[4]:m[5]])))
+
+		for i := m[2]; i < m[1]; i++ {
+			buf[i] = ' '
+		}
+	}
+
+	return buf
+}
+
+// ------------------------------------------------------------
+
+// From stats and pool lines, where the depths of queues and the counts
+// of workers are reported by ':', '=' or a space...
+//
+//	dcp feed stats queue_len: 42, workers: 8, pending=3 backlog: 17 items
+//	worker pool: idle_workers=2 queue depth: 120 max_queue_size=1000
+//	mutation queue length 55 (cap 100)
+var re_queue_metric = regexp.MustCompile(
+	`(?i)\b((?:[a-z]+_)*(?:queue|workers?|threads?|pending|backlog|inflight)(?:_[a-z]+)*` +
+		`|queue (?:depth|length|len|size))(?:\s*[:=]\s*|\s+)(\d+)\b`)
+
+// extractQueueMetrics emits the queue depths and the worker counts as
+// INT VALS parts, whose names have their spaces replaced by '_', like
+// "queue_depth", so their saturation is tracked over time, and blanks
+// them, as the tokenizer would otherwise merge a name into its
+// preceding words or would miss a name that's followed by a space.
+func extractQueueMetrics(p *fileProcessor, buf []byte,
+	emit func(path []string, name, valType, val string)) []byte {
+	for _, m := range re_queue_metric.FindAllSubmatchIndex(buf, -1) {
+		name := strings.ToLower(strings.Replace(string(buf[m[2]:m[3]]), " ", "_", -1))
+
+		emit(nil, name, "INT", string(buf[m[4]:m[5]]))
+
+		for i := m[0]; i < m[1]; i++ {
+			buf[i] = ' '
+		}
+	}
+
+	return buf
+}
+
+// ------------------------------------------------------------
+
+// From ns_server.info.log, after the cleanser's stringification...
+//
+//	got {error,timeout} nodes: ['ns_1@10.0.0.1','ns_1@10.0.0.2']
+//	{nodes_wanted,['ns_1@10.0.0.1','ns_1@10.0.0.2']}
+var re_named_list = regexp.MustCompile(`([A-Za-z_]\w*)\s*[:,=]\s*\[`)
+
+// extractLists emits erlang lists of atoms, pids or nodes as single
+// VALS parts whose value is a JSON array, like ["ns_1@a","ns_1@b"],
+// and blanks the list, so the tokenizer doesn't fragment the list.
+func extractLists(p *fileProcessor, buf []byte,
+	emit func(path []string, name, valType, val string)) []byte {
+	for at := 0; at < len(buf); {
+		m := re_named_list.FindSubmatchIndex(buf[at:])
+		if m == nil {
+			break
+		}
+
+		start, lbrack := at+m[0], at+m[1]-1
+		name := string(buf[at+m[2] : at+m[3]])
+
+		end := balancedEnd(buf, lbrack)
+		if end < 0 {
+			break
+		}
+
+		at = end
+
+		if bytes.ContainsAny(buf[lbrack+1:end-1], "{[(") {
+			continue // Only lists of simple elements are handled.
+		}
+
+		var elems []string
+		for _, elem := range strings.Split(string(buf[lbrack+1:end-1]), ",") {
+			elem = strings.Trim(elem, " \t\n\"'")
+			if elem != "" {
+				elems = append(elems, elem)
+			}
+		}
+		if len(elems) <= 0 {
+			continue
+		}
+
+		j, err := json.Marshal(elems)
+		if err != nil {
+			continue
+		}
+
+		emit(nil, name, "STRING", string(j))
+
+		for i := start; i < end; i++ {
+			buf[i] = ' '
+		}
+	}
+
+	return buf
+}
+
+// From memcached and ns_server, where quoted paths are also found...
+//
+//	2016-04-14T16:10:09.463447-07:00 WARNING Failed to open /opt/couchbase/var/lib/couchbase/data/default/0.couch.1
+//	{dbdir,"/opt/couchbase/var/lib/couchbase/data"}
+var re_fs_path = regexp.MustCompile(`(?:^|[\s=:,'"(\[{])("?)(/(?:[\w.@%+-]+/)+[\w.@%+-]*)"?`)
+
+// extractPaths emits absolute filesystem paths, of at least two path
+// elements, as path VALS parts, and rewrites them as quoted strings,
+// before the tokenizer would split them on '/'.  URLs aren't matched,
+// as their paths don't follow a separator.
+func extractPaths(p *fileProcessor, buf []byte,
+	emit func(path []string, name, valType, val string)) []byte {
+	ms := re_fs_path.FindAllSubmatchIndex(buf, -1)
+	if len(ms) <= 0 {
+		return buf
+	}
+
+	rewritten := make([]byte, 0, len(buf)+4*len(ms))
+
+	last := 0
+	for _, m := range ms {
+		path := string(buf[m[4]:m[5]])
+
+		emit(nil, "path", "STRING", path)
+
+		rewritten = append(rewritten, buf[last:m[2]]...)
+		rewritten = append(rewritten, ' ')
+		rewritten = append(rewritten, strconv.Quote(path)...)
+		rewritten = append(rewritten, ' ')
+
+		last = m[1]
+	}
+
+	return append(rewritten, buf[last:]...)
+}
+
+// ------------------------------------------------------------
+
+// From ns_server.info.log, after the cleanser's stringification...
+//
+//	[error_logger:error,2016-04-14T16:10:07.262-07:00,ns_1@127.0.0.1:error_logger<0.6.0>:...]
+//	=========================CRASH REPORT=========================
+//	  crasher:
+//	    initial call: ns_janitor:init/1
+//	    pid: <0.2011.0>
+//	    registered_name: ns_janitor
+//	    exception exit: {badmatch,{error,enoent}}
+var re_crash_initial_call = regexp.MustCompile(`(?m)^\s*initial call:\s*("?([^"\s]+)"?)`)
+
+var re_crash_registered_name = regexp.MustCompile(`(?m)^\s*registered_name:\s*(\S+)`)
+
+var re_crash_pid = regexp.MustCompile(`(?m)^\s*pid:\s*"?(<[\d.]+>)`)
+
+// extractCrashReport emits the initial call of the crashed process of
+// an erlang crash report as an initial_call VALS part, which is then
+// blanked, as the tokenizer would otherwise split the "m:f/a", and
+// emits the crashed process's registered name, or else its pid, as a
+// crash_process VALS part.
+func extractCrashReport(p *fileProcessor, buf []byte,
+	emit func(path []string, name, valType, val string)) []byte {
+	if !bytes.Contains(buf, []byte("CRASH REPORT")) {
+		return buf
+	}
+
+	crashProcess := ""
+	if m := re_crash_registered_name.FindSubmatch(buf); m != nil &&
+		string(m[1]) != "[]" {
+		crashProcess = string(m[1])
+	} else if m := re_crash_pid.FindSubmatch(buf); m != nil {
+		crashProcess = string(m[1])
+	}
+
+	if crashProcess != "" {
+		emit(nil, "crash_process", "STRING", crashProcess)
+	}
+
+	m := re_crash_initial_call.FindSubmatchIndex(buf)
+	if m != nil {
+		emit(nil, "initial_call", "STRING", string(buf[m[4]:m[5]]))
+
+		for i := m[2]; i < m[3]; i++ {
+			buf[i] = ' '
+		}
+	}
+
+	return buf
+}
+
+// ------------------------------------------------------------
+
+// From memcached.log, after the EntryRE's prefix is stripped...
+//
+//	37: HELO curr_items=5 conn_id=12
+//	55: Invalid packet (opcode 0x89, status: 0x01) - Closing connection
+//	123: Invalid format - Status: "Invalid arguments" - Closing connection. Opcode: GET
+var re_memcached_conn_id = regexp.MustCompile(`^\s*(\d+):\s`)
+
+var re_memcached_opcode = regexp.MustCompile(`(?i)\bopcode\s*[:=]?\s*(0x[0-9a-f]+|[A-Z][A-Z_]*)\b`)
+
+var re_memcached_status = regexp.MustCompile(`(?i)\bstatus\s*[:=]?\s*(?:"([^"]+)"|(0x[0-9a-f]+)\b)`)
+
+// extractMemcachedConn emits the connection id that prefixes a
+// memcached entry, and any protocol opcode and status, as conn_id,
+// opcode and mc_status VALS parts, which are then blanked, as they're
+// the key fields for diagnosing client issues, where the mc_status is
+// distinct from the normalized status VALS part of the Status param.
+func extractMemcachedConn(p *fileProcessor, buf []byte,
+	emit func(path []string, name, valType, val string)) []byte {
+	if m := re_memcached_conn_id.FindSubmatchIndex(buf); m != nil {
+		emit(nil, "conn_id", "INT", string(buf[m[2]:m[3]]))
+
+		for i := m[2]; i < m[3]+1; i++ { // Also blanks the ':'.
+			buf[i] = ' '
+		}
+	}
+
+	for _, m := range re_memcached_opcode.FindAllSubmatch(buf, -1) {
+		emit(nil, "opcode", "STRING", string(m[1]))
+	}
+	buf = re_memcached_opcode.ReplaceAll(buf, []byte(" "))
+
+	for _, m := range re_memcached_status.FindAllSubmatch(buf, -1) {
+		status := string(m[1])
+		if status == "" {
+			status = string(m[2])
+		}
+		emit(nil, "mc_status", "STRING", status)
+	}
+
+	return re_memcached_status.ReplaceAll(buf, []byte(" "))
+}
+
+// ------------------------------------------------------------
+
+// CBVersionLines is the number of first lines of a file that are
+// searched for a version banner, for the file's FILE record.
+var CBVersionLines = 1000
+
+// From the version banners of ns_server, babysitter and memcached...
+//
+//	[ns_server:info,2021-03-01T10:00:00.123-07:00,ns_1@127.0.0.1:<0.1.0>:...]Couchbase Server 7.1.0-2556-enterprise
+//	{version,"7.1.0-2556-enterprise"}
+//	2021-03-01T10:00:00.123-07:00 INFO Couchbase version 7.1.0-2556 starting.
+var re_cb_version = regexp.MustCompile(
+	`(?i)\b(?:couchbase|version)\b\D{0,40}?\b(\d+\.\d+\.\d+)-(\d+)(?:-(?:enterprise|community))?\b`)
+
+// cbVersionOf returns the Couchbase version and build of the first
+// version banner in the lines, like "7.1.0" and "2556", or "".
+func cbVersionOf(lines []string) (string, string) {
+	for _, line := range lines {
+		if m := re_cb_version.FindStringSubmatch(line); m != nil {
+			return m[1], m[2]
+		}
+	}
+	return "", ""
+}
+
+// ------------------------------------------------------------
+
+// IndexPhase is a signature of a phase of an index's lifecycle.
+type IndexPhase struct {
+	Phase string
+	RE    *regexp.Regexp
+}
+
+// IndexPhases are matched against the cleansed entries of the indexer
+// log, where an embedder may add or replace signatures.
+var IndexPhases = []IndexPhase{
+	{"created", regexp.MustCompile(`(?i)\b(?:created|creating) index\b|\bindex created\b`)},
+	{"build_start", regexp.MustCompile(`(?i)\bbuild (?:started|initiated)\b|\bbuilding index\b`)},
+	{"build_done", regexp.MustCompile(`(?i)\bbuild (?:completed|done|finished)\b`)},
+	{"scan_start", regexp.MustCompile(`(?i)\bscan (?:started|request received)\b`)},
+	{"scan_done", regexp.MustCompile(`(?i)\bscan (?:completed|done|finished)\b`)},
+	{"dropped", regexp.MustCompile(`(?i)\b(?:dropped|dropping) index\b|\bindex dropped\b`)},
+}
+
+// From indexer, where the index instance id is the first long number
+// of the entry, or else is in a banner line of the entry...
+//
+//	==== Index Instance 12648800643524082356 ====
+//	2016-04-12T10:35:32.355+01:00 [Info] Build started for index 17632878461435344554
+var re_index_instance = regexp.MustCompile(`==== Index Instance (\d+) ====`)
+
+var re_index_id = regexp.MustCompile(`\b(\d{10,})\b`)
+
+// extractIndexPhase emits an index_phase VALS part when an entry
+// matches one of the IndexPhases, along with the index_id of the
+// index instance, when found, for an index lifecycle timeline.
+func extractIndexPhase(p *fileProcessor, buf []byte,
+	emit func(path []string, name, valType, val string)) []byte {
+	for _, ip := range IndexPhases {
+		if !ip.RE.Match(buf) {
+			continue
+		}
+
+		emit(nil, "index_phase", "STRING", ip.Phase)
+
+		// A banner is a continuation line of the previous entry, so
+		// it's only a fallback for an id in the entry's own text.
+		if m := re_index_id.FindSubmatch(re_index_instance.ReplaceAll(buf, nil)); m != nil {
+			emit(nil, "index_id", "STRING", string(m[1]))
+		} else if m := re_index_instance.FindSubmatch(buf); m != nil {
+			emit(nil, "index_id", "STRING", string(m[1]))
+		}
+
+		break
+	}
+
+	return buf
+}
+
+// ------------------------------------------------------------
+
+// From ns_server's rebalance, where a vbucket's chain of nodes, whose
+// head is the active node, moves from an old chain to a new chain...
+//
+//	Starting vbucket move for bucket "default", vbucket 123 from ['ns_1@10.0.0.1'] to ['ns_1@10.0.0.2','ns_1@10.0.0.1']
+//	Moving vbucket 512 from 'ns_1@cb1.local' to 'ns_1@cb2.local'
+//	{move,{123,['ns_1@10.0.0.1',undefined],['ns_1@10.0.0.2','ns_1@10.0.0.1']}}
+var re_rebalance_move = regexp.MustCompile(
+	`\bvbucket\s+(\d+)\s+from\s+(\[[^\]]*\]|\S+)\s+to\s+(\[[^\]]*\]|\S+)`)
+
+var re_rebalance_move_tuple = regexp.MustCompile(
+	`\{\s*move\s*,\s*\{\s*(\d+)\s*,\s*(\[[^\]]*\])\s*,\s*(\[[^\]]*\])`)
+
+// extractRebalanceMove emits the vbucket, from_node and to_node VALS
+// parts of a rebalance's vbucket move, where the nodes are the heads
+// of the old and new chains, and blanks the vbucket and the chains,
+// so the tokenizer doesn't fragment or repeat them.
+func extractRebalanceMove(p *fileProcessor, buf []byte,
+	emit func(path []string, name, valType, val string)) []byte {
+	m := re_rebalance_move.FindSubmatchIndex(buf)
+	if m == nil {
+		m = re_rebalance_move_tuple.FindSubmatchIndex(buf)
+		if m == nil {
+			return buf
+		}
+	}
+
+	emit(nil, "vbucket", "INT", string(buf[m[2]:m[3]]))
+
+	if node := chainHead(buf[m[4]:m[5]]); node != "" {
+		emit(nil, "from_node", "STRING", node)
+	}
+	if node := chainHead(buf[m[6]:m[7]]); node != "" {
+		emit(nil, "to_node", "STRING", node)
+	}
+
+	for _, i := range []int{2, 4, 6} {
+		for j := m[i]; j < m[i+1]; j++ {
+			buf[j] = ' '
+		}
+	}
+
+	return buf
+}
+
+// chainHead returns the first node of a vbucket chain, like the
+// "ns_1@a" of "['ns_1@a','ns_1@b']", or "" for an undefined head.
+func chainHead(chain []byte) string {
+	head := strings.Trim(string(chain), "[]")
+	if comma := strings.IndexByte(head, ','); comma >= 0 {
+		head = head[0:comma]
+	}
+
+	head = strings.Trim(head, " \t\n\"'")
+	if head == "undefined" {
+		return ""
+	}
+
+	return head
+}
+
+// ------------------------------------------------------------
+
+// From ns_server's mb_master, where a node announces that it's the
+// master, or orchestrator, or where the orchestrator moves to a node...
+//
+//	I'm the only node, so I'm the master.
+//	Haven't heard from a higher priority node or a master, so I'm taking over.
+//	Orchestrator moved from 'ns_1@10.0.0.1' to 'ns_1@10.0.0.2'
+//	New orchestrator: "ns_1@10.0.0.2"
+var re_orchestrator_self = regexp.MustCompile(
+	`(?i)\b(?:I'm the master|so I'm taking over|becoming master|starting as master)\b`)
+
+var re_orchestrator_node = regexp.MustCompile(
+	`(?i)\b(?:new orchestrator|orchestrator (?:change[ds]?|moved)|master (?:node )?change[ds]?)\b` +
+		`(?:.*?\bto\b)?[^\n]*?['"]?(n(?:s_1|_\d+)@[A-Za-z0-9.:-]*[A-Za-z0-9])`)
+
+// extractOrchestrator emits an orchestrator VALS part, of the node
+// that's announced as the master or orchestrator, where a node that
+// announces itself is the node of the entry's cbcollect directory,
+// for a timeline of the orchestrator changes.
+func extractOrchestrator(p *fileProcessor, buf []byte,
+	emit func(path []string, name, valType, val string)) []byte {
+	if m := re_orchestrator_node.FindSubmatch(buf); m != nil {
+		emit(nil, "orchestrator", "STRING", string(m[1]))
+	} else if re_orchestrator_self.Match(buf) {
+		emit(nil, "orchestrator", "STRING", p.run.anonymize(nodeOf(p.dirBase)))
+	}
+
+	return buf
+}
+
+// ------------------------------------------------------------
+
+// From the endpoints of connections, whether of a host, an IP address
+// or a URL...
+//
+//	Trying with http://127.0.0.1:8091/pools/default/bucketsStreaming/default
+//	connected to cb1.local:11210, bucket: default
+//	dial tcp [::1]:8093: connect: connection refused
+var re_endpoint = regexp.MustCompile(
+	`(?:\b\d+\.\d+\.\d+\.\d+|\blocalhost|\b[A-Za-z][\w-]*(?:\.[\w-]+)+|\[[0-9A-Fa-f:.]+\]):(\d{2,5})\b`)
+
+// servicesOf returns the distinct services, in order of appearance,
+// of the known ports of the endpoints in buf, like "query" for an
+// endpoint like "127.0.0.1:8093".
+func servicesOf(servicePorts map[string]string, buf []byte) []string {
+	var rv []string
+
+	for _, m := range re_endpoint.FindAllSubmatch(buf, -1) {
+		service, exists := servicePorts[string(m[1])]
+		if !exists {
+			continue
+		}
+
+		seen := false
+		for _, s := range rv {
+			seen = seen || s == service
+		}
+		if !seen {
+			rv = append(rv, service)
+		}
+	}
+
+	return rv
+}
+
+// OptionalExtractors are the Extractors that aren't part of any
+// FileMeta, and which are applied to the entries of every file only
+// when they're named in the Extract param.
+var OptionalExtractors = map[string]Extractor{
+	"collections": extractCollections,
+}
+
+// From memcached and query of Couchbase 7.x, which have scopes and
+// collections, as names, as hex collection ids, or as a keyspace...
+//
+//	2021-03-01T10:00:00.123-07:00 INFO 42: (travel-sample) DCP stream cid:0x8 sid:0x0
+//	scope:"inventory" collection:"airline"
+//	_time=2021-03-01T10:00:00.123-07:00 _level=INFO keyspace default:travel-sample.inventory.airline
+var re_scope_id = regexp.MustCompile(`\b(?:scope|sid)\s*[:=]\s*"?(0x[0-9a-fA-F]+|[\w%-]+)"?`)
+
+var re_collection_id = regexp.MustCompile(`\b(?:collection|cid)\s*[:=]\s*"?(0x[0-9a-fA-F]+|[\w%-]+)"?`)
+
+var re_keyspace = regexp.MustCompile(`\bdefault:` + "`?" + `[\w%-]+` + "`?" + `\.` +
+	"`?" + `([\w%-]+)` + "`?" + `\.` + "`?" + `([\w%-]+)` + "`?")
+
+// extractCollections emits the scope and collection names or ids of
+// Couchbase 7.x logs as scope and collection VALS parts, and blanks
+// them, as the tokenizer would otherwise split names like
+// "travel-sample" and keyspaces on their '-' and '.' separators.
+func extractCollections(p *fileProcessor, buf []byte,
+	emit func(path []string, name, valType, val string)) []byte {
+	for _, m := range re_keyspace.FindAllSubmatch(buf, -1) {
+		emit(nil, "scope", "STRING", string(m[1]))
+		emit(nil, "collection", "STRING", string(m[2]))
+	}
+	buf = re_keyspace.ReplaceAll(buf, []byte(" "))
+
+	for _, m := range re_scope_id.FindAllSubmatch(buf, -1) {
+		emit(nil, "scope", "STRING", string(m[1]))
+	}
+	buf = re_scope_id.ReplaceAll(buf, []byte(" "))
+
+	for _, m := range re_collection_id.FindAllSubmatch(buf, -1) {
+		emit(nil, "collection", "STRING", string(m[1]))
+	}
+
+	return re_collection_id.ReplaceAll(buf, []byte(" "))
+}
+
+// ------------------------------------------------------------
+
+// balancedEnd returns the offset just past the bracket that closes
+// the '{', '[' or '(' at buf[start], skipping over double-quoted
+// strings, or -1 when the brackets are unbalanced.
+func balancedEnd(buf []byte, start int) int {
+	depth := 0
+	inQuote := false
+
+	for i := start; i < len(buf); i++ {
+		c := buf[i]
+
+		if inQuote {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inQuote = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inQuote = true
+		case '{', '[', '(':
+			depth++
+		case '}', ']', ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+			if depth < 0 {
+				return -1
+			}
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,139 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtractCrashReport(t *testing.T) {
+	// The cleanser has already quoted the initial call and pid.
+	buf := []byte("\n=========================CRASH REPORT=========================\n" +
+		"  crasher:\n" +
+		"    initial call: \"ns_janitor:init/1\"\n" +
+		"    pid: \"<0.2011.0>\"\n" +
+		"    registered_name: []\n")
+
+	emitted := map[string]string{}
+
+	buf = extractCrashReport(nil, buf, func(path []string, name, valType, val string) {
+		emitted[name] = val
+	})
+
+	if emitted["initial_call"] != "ns_janitor:init/1" ||
+		emitted["crash_process"] != "<0.2011.0>" {
+		t.Errorf("expected the unquoted initial_call and pid, got: %v", emitted)
+	}
+
+	if strings.Contains(string(buf), "ns_janitor") {
+		t.Errorf("expected the initial call to be blanked, got: %q", buf)
+	}
+}
+
+func TestCrashReportFixtures(t *testing.T) {
+	out := runFixture(t, "-emitParts", "VALS", "-emitTypes", "STRING",
+		"testdata/crash")
+
+	expectLines(t, out,
+		`2016-04-14T16:10:07.262 ERRO crash/ns_server.info.log 12:5 error_logger crash_process = STRING "ns_janitor"`,
+		`2016-04-14T16:10:07.262 ERRO crash/ns_server.info.log 12:5 error_logger initial_call = STRING "ns_janitor:init/1"`,
+		`2016-04-14T16:10:08.262 ERRO crash/ns_server.info.log 500:16 error_logger crash_process = STRING "<0.3012.0>"`,
+		`2016-04-14T16:10:08.262 ERRO crash/ns_server.info.log 500:16 error_logger initial_call = STRING "menelaus_web:handle_request/2"`)
+}
+
+func TestMemcachedConnFixtures(t *testing.T) {
+	out := runFixture(t, "-status", "-emitParts", "VALS", "-emitTypes", "INT,STRING",
+		"testdata/memcached")
+
+	expectLines(t, out,
+		`2016-04-14T16:10:10.463 NOTI memcached/memcached.log 12:5 memcached conn_id = INT 37`,
+		`2016-04-14T16:10:11.463 WARN memcached/memcached.log 74:6 memcached conn_id = INT 55`,
+		`2016-04-14T16:10:11.463 WARN memcached/memcached.log 74:6 memcached opcode = STRING "0x89"`,
+		`2016-04-14T16:10:11.463 WARN memcached/memcached.log 74:6 memcached mc_status = STRING "0x01"`,
+		`2016-04-14T16:10:11.463 WARN memcached/memcached.log 74:6 memcached status = STRING "unknown"`,
+		`2016-04-14T16:10:12.463 WARN memcached/memcached.log 183:7 memcached opcode = STRING "GET"`,
+		`2016-04-14T16:10:12.463 WARN memcached/memcached.log 183:7 memcached mc_status = STRING "Invalid arguments"`)
+}
+
+func TestCBVersionGated(t *testing.T) {
+	out := runFixture(t, "-emitParts", "VALS", "-emitTypes", "INT,STRING",
+		"testdata/version")
+	if strings.Contains(out, "cb_version") {
+		t.Errorf("expected no cb_version without attachVersion or FILE, got:\n%s", out)
+	}
+
+	out = runFixture(t, "-attachVersion", "-emitParts", "VALS", "-emitTypes", "INT,STRING",
+		"testdata/version")
+	if strings.Count(out, `cb_version = STRING "7.1.0"`) != 2 {
+		t.Errorf("expected every entry's cb_version with attachVersion, got:\n%s", out)
+	}
+
+	out = runFixture(t, "-emitParts", "FILE", "testdata/version")
+	if !strings.Contains(out, `cb_version="7.1.0" cb_build=2556`) {
+		t.Errorf("expected the FILE record's cb_version, got:\n%s", out)
+	}
+}
+
+func TestRebalanceMoveFixtures(t *testing.T) {
+	out := runFixture(t, "-emitParts", "VALS", "-emitTypes", "INT,STRING",
+		"testdata/rebalance")
+
+	// The tuple's undefined head of the old chain has no from_node.
+	expectLines(t, out,
+		`2016-04-14T16:10:05.262 INFO rebalance/ns_server.info.log 12:5 ns_server vbucket = INT 123`,
+		`2016-04-14T16:10:05.262 INFO rebalance/ns_server.info.log 12:5 ns_server from_node = STRING "ns_1@10.0.0.1"`,
+		`2016-04-14T16:10:05.262 INFO rebalance/ns_server.info.log 12:5 ns_server to_node = STRING "ns_1@10.0.0.2"`,
+		`2016-04-14T16:10:05.262 INFO rebalance/ns_server.info.log 12:5 ns_server spawn_mover = INT 110`,
+		`2016-04-14T16:10:06.262 INFO rebalance/ns_server.info.log 240:7 ns_server vbucket = INT 512`,
+		`2016-04-14T16:10:06.262 INFO rebalance/ns_server.info.log 240:7 ns_server from_node = STRING "ns_1@cb1.local"`,
+		`2016-04-14T16:10:06.262 INFO rebalance/ns_server.info.log 240:7 ns_server to_node = STRING "ns_1@cb2.local"`,
+		`2016-04-14T16:10:06.262 INFO rebalance/ns_server.info.log 240:7 ns_server move = INT 210`,
+		`2016-04-14T16:10:07.262 INFO rebalance/ns_server.info.log 399:9 ns_server vbucket = INT 7`,
+		`2016-04-14T16:10:07.262 INFO rebalance/ns_server.info.log 399:9 ns_server to_node = STRING "ns_1@10.0.0.3"`,
+		`2016-04-14T16:10:07.262 INFO rebalance/ns_server.info.log 399:9 ns_server plan = INT 320`)
+}
+
+func TestStreamTopicFixtures(t *testing.T) {
+	out := runFixture(t, "-emitParts", "VALS", "-emitTypes", "INT,STRING",
+		"testdata/projector")
+
+	expectLines(t, out,
+		`2016-04-12T10:17:35.286 INFO projector/ns_server.projector.log 12:5 projector stream_topic = STRING "MAINT_STREAM_TOPIC_bb:44:4a:7f:f5:90:d5:91"`,
+		`2016-04-12T10:17:35.301 INFO projector/ns_server.projector.log 148:6 projector stream_topic = STRING "INIT_STREAM_TOPIC_bb:44:4a:7f:f5:90:d5:91"`,
+		`2016-04-12T10:17:36.301 INFO projector/ns_server.projector.log 304:7 projector stream_topic = STRING "BACKFILL_STREAM_TOPIC_0a:1B"`,
+		`2016-04-12T10:17:36.301 INFO projector/ns_server.projector.log 304:7 projector stream_topic = STRING "INIT_STREAM_TOPIC_0a:1b"`,
+		`2016-04-12T10:17:36.301 INFO projector/ns_server.projector.log 304:7 projector stream_topic = STRING "STREAM_TOPIC_ff"`)
+
+	// The blanked topics aren't split into VALS parts by the tokenizer,
+	// and a #TOPIC_ without STREAM_ isn't a stream topic.
+	if n := strings.Count(out, " = "); n != 5 {
+		t.Errorf("expected only the 5 stream_topic parts, got %d:\n%s", n, out)
+	}
+}
+
+func TestServiceFromPortAnonymized(t *testing.T) {
+	for _, args := range [][]string{nil, {"-anonymize", "-anonymizeSalt", "s"}} {
+		args = append(args, "-serviceFromPort",
+			"-emitParts", "VALS", "-emitTypes", "STRING", "testdata/services")
+
+		out := runFixture(t, args...)
+
+		// The ports are matched before the cleanser quotes the IP address,
+		// and before the IP address and the host are anonymized.
+		for _, service := range []string{"kv", "query"} {
+			if !strings.Contains(out, `service = STRING "`+service+`"`) {
+				t.Errorf("args: %q, expected service %s, got:\n%s", args, service, out)
+			}
+		}
+	}
+}
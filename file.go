@@ -13,129 +13,1470 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"go/scanner"
 	"go/token"
 )
 
 type fileProcessor struct {
-	run       *Run
-	dir       string
-	dirBase   string
-	fname     string
-	fnameBase string // Ex: fname of "ns_server.fts.log" has fnameBase of "fts".
-	fnameOut  string // Space right padded "dirBase/fname", ready for logging.
-	fmeta     FileMeta
-	dict      Dict
-	buf       []byte // Reusable buf to reduce garbage.
+	run         *Run
+	url         string // Optional, when the file is an http or https URL.
+	dir         string
+	dirBase     string
+	dirBaseAnon string // The emitted dirBase, which is pseudonymized with Anonymize.
+	fname       string
+	fnameAnon   string // The emitted fname, which is pseudonymized with Anonymize.
+	fnameBase   string // Ex: fname of "ns_server.fts.log" has fnameBase of "fts".
+	fnameOut    string // Space right padded "dirBase/fname", ready for logging.
+	fmeta       FileMeta
+	dict        Dict
+	dictFull    bool   // True once the dict is truncated by the MaxDictSize.
+	buf         []byte // Reusable buf to reduce garbage.
+	explain     bool   // True while processing an entry that's explained.
+	tokDebug    bool   // True while tokenizing an entry, for TokenizerDebug.
+
+	mtime time.Time // Modification time of the file, when known.
+
+	// The Couchbase version and build of the file's first version
+	// banner, when found, like "7.1.0" and "2556".
+	cbVersion, cbBuild string
+
+	follow bool // When true, the file is followed as it grows.
+
+	// True when the file is gunzip'ed, whose offsets are then of the
+	// uncompressed stream, so they're not comparable with its size.
+	gzipped bool
+
+	// Optional, the content of a file of a bundle, as it's streamed,
+	// which can only be read once.
+	bundled       io.Reader
+	bundledOpened bool
+
+	entriesParsed int64 // Count of entries whose timestamp was parsed.
+
+	// Count of the scanned entries whose first line matched the entry
+	// regexps, before any filtering, like by the Tail or OnlyModules.
+	entriesMatched int64
+
+	// levelCounts is keyed by LevelHistogram bucket timestamp, then by
+	// level, counting the entries that weren't filtered out.
+	levelCounts map[string]map[string]int64
+
+	// When captureEmits is true, emits are appended to the emits
+	// rather than being invoked, as used by an entryPipeline.
+	captureEmits bool
+	emits        []func()
+
+	// When non-nil, emits are appended to the traced entry rather than
+	// being invoked, for the TraceID param.
+	traced *tracedEntry
+
+	// seqLasts is keyed by module, tracking the last seen sequence
+	// number of the module's entries, when a SeqRE is used.
+	seqLasts map[string]int64
+
+	lastTS time.Time // Timestamp of the previous entry, for RotationThreshold.
+
+	replayTS time.Time // Timestamp of the previous entry, for ReplayDelay.
+
+	inferTS time.Time // Timestamp of the previous entry, for InferDate.
+
+	entryNames []string // Names added to the dict by the current entry, for EmitCooccur.
+
+	// When non-nil, the VALS of a nested level deeper than the
+	// MaxPathDepth are being collected, rather than being emitted.
+	deepVals map[string]interface{}
+
+	// latencyStarts is keyed by id, tracking the timestamps of the
+	// entries that matched the LatencyStartRE, awaiting their end.
+	latencyStarts map[string]time.Time
+	latencySwept  time.Time // When latencyStarts was last swept.
+
+	truncated *truncatedEntries // Entries with an oversized line.
+}
+
+// A tokLit associates a token and a literal string.
+type tokLit struct {
+	tok     token.Token
+	lit     string
+	emitted bool // Marked true when this tokLit has been emitted.
+}
+
+// ------------------------------------------------------------
+
+func (p *fileProcessor) process() error {
+	if p.run.ProgressEvery <= 0 {
+		fmt.Fprintf(os.Stderr, "processing %s/%s\n", p.dirBaseAnon, p.fnameAnon)
+	}
+
+	f, err := p.open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if p.follow {
+		f = &followReader{f, p.run.WatchInterval}
+	}
+
+	if p.run.TeeRaw != "" {
+		tee, err := p.createTee()
+		if err != nil {
+			return err
+		}
+		defer tee.Close()
+
+		f = teeReadCloser{io.TeeReader(f, tee), f}
+	}
+
+	p.emitFileRecord()
+
+	if p.fmeta.Tokenizer == "journal" {
+		return p.processJournal(f)
+	}
+
+	if p.fmeta.Tokenizer == "json-stream" {
+		return p.processJSONStream(f)
+	}
+
+	if p.fmeta.Tokenizer == "xdcr_trace" {
+		return p.processXDCRTrace(f)
+	}
+
+	if p.run.Reverse && p.run.Tail <= 0 {
+		p.run.m.Lock()
+		fsize := p.run.fileSizes[p.dirBase][p.fname]
+		p.run.m.Unlock()
+
+		// The uncompressed size of a gzip'ed file is unknown.
+		if fsize < 0 || fsize > ReverseMaxFileSize || p.gzipped {
+			return fmt.Errorf("reverse needs a tail for file: %s/%s, size: %d",
+				p.dirBaseAnon, p.fnameAnon, fsize)
+		}
+	}
+
+	p.truncated = &truncatedEntries{offsets: map[int64]bool{}}
+
+	processEntry := p.processEntry
+
+	// When true, the entryLines slice is reused across entries, which
+	// is safe only when processEntry is done with the lines before it
+	// returns, so a pipeline instead gets a newly allocated slice.
+	reuseLines := p.run.BufferReuse
+
+	// The emits of the traced entries, and so their lines, are held
+	// until all the files are processed.
+	if p.run.TraceID != "" {
+		reuseLines = false
+	}
+
+	if p.run.EntryWorkers > 1 && !p.run.needsOrderedEntries() {
+		ep := p.startEntryPipeline(p.run.EntryWorkers)
+		defer ep.finish()
+
+		processEntry = ep.processEntry
+		reuseLines = false
+	}
+
+	var buffered []bufferedEntry // Used by the Tail and Reverse params.
+
+	var handled int // Count of entries handled, for the MaxEntries param.
+
+	var grouped []bufferedEntry // Used by the GroupBy param.
+
+	// flushGrouped processes the grouped entries, where the entries of
+	// a group are contiguous, and the groups are in the order of their
+	// first entries.
+	flushGrouped := func() {
+		var keys []string
+		groups := map[string][]int{}
+
+		for i, e := range grouped {
+			key := p.groupKey(e.lines[0])
+			if key == "" { // An entry without a key is its own group.
+				key = "\x00" + strconv.Itoa(i)
+			}
+			if _, exists := groups[key]; !exists {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], i)
+		}
+
+		for _, key := range keys {
+			for _, i := range groups[key] {
+				processEntry(grouped[i].startOffset, grouped[i].startLine, grouped[i].lines)
+			}
+		}
+
+		grouped = grouped[0:0]
+	}
+
+	if p.run.GroupBy != "" {
+		defer flushGrouped()
+	}
+
+	handleEntry := func(startOffset, startLine int64, lines []string) {
+		if p.run.GroupBy != "" && p.run.Tail <= 0 && !p.run.Reverse {
+			if startLine > 0 && len(lines) > 0 {
+				handled++
+
+				grouped = append(grouped, bufferedEntry{startOffset, startLine,
+					append([]string(nil), lines...)})
+				if len(grouped) >= p.run.GroupByWindow || p.run.memoryPressure() {
+					flushGrouped()
+				}
+			}
+			return
+		}
+
+		if p.run.Tail <= 0 && !p.run.Reverse {
+			if startLine > 0 && len(lines) > 0 {
+				handled++
+			}
+			processEntry(startOffset, startLine, lines)
+			return
+		}
+
+		if len(lines) > 0 {
+			buffered = append(buffered, bufferedEntry{startOffset, startLine,
+				append([]string(nil), lines...)})
+
+			if p.run.Tail > 0 && len(buffered) >= 2*p.run.Tail {
+				buffered = append(buffered[0:0], buffered[len(buffered)-p.run.Tail:]...)
+			}
+
+			if p.run.memoryPressure() {
+				if p.run.Tail > 0 {
+					if len(buffered) > p.run.Tail {
+						buffered = append(buffered[0:0], buffered[len(buffered)-p.run.Tail:]...)
+					}
+				} else { // Reverse, which degrades to reversed runs of entries.
+					for i := len(buffered) - 1; i >= 0; i-- {
+						processEntry(buffered[i].startOffset, buffered[i].startLine, buffered[i].lines)
+						handled++
+					}
+					buffered = buffered[0:0]
+				}
+			}
+		}
+	}
+
+	// stop returns true once no more entries should be handled.
+	stop := func() bool {
+		return p.run.outputLimited() ||
+			(p.run.MaxEntries > 0 && handled >= p.run.MaxEntries)
+	}
+
+	// The offset and line where the scan starts, of the Seek param, or
+	// else of the last restart of a seekable file.
+	seekOffset, seekLine := p.run.seekOffset, p.run.seekLine
+
+	// Used by the SinceLastRestart mode for a file that can't be scanned
+	// twice, like of a bundle, of a URL or a gzip'ed file, whose entries
+	// since the latest entry with a restart marker line are held back
+	// until the whole file is scanned.
+	var sinceRestart []bufferedEntry
+	var sinceRestartBytes int64
+
+	flushSinceRestart := func() {}
+
+	if p.run.restartRE != nil && p.seekable() {
+		restartOffset, restartLine, err := p.lastRestart()
+		if err != nil {
+			return err
+		}
+
+		if restartOffset > seekOffset {
+			seekOffset, seekLine = restartOffset, restartLine
+		}
+	} else if p.run.restartRE != nil {
+		handleEntryNext := handleEntry
+
+		flushSinceRestart = func() {
+			for _, e := range sinceRestart {
+				handleEntryNext(e.startOffset, e.startLine, e.lines)
+				if stop() {
+					break
+				}
+			}
+
+			sinceRestart = sinceRestart[0:0]
+			sinceRestartBytes = 0
+		}
+
+		var flushedEarly bool
+
+		handleEntry = func(startOffset, startLine int64, lines []string) {
+			if startLine <= 0 || len(lines) <= 0 {
+				return
+			}
+
+			for _, line := range lines {
+				if p.run.restartRE.MatchString(line) {
+					sinceRestart = sinceRestart[0:0]
+					sinceRestartBytes = 0
+					break
+				}
+			}
+
+			sinceRestart = append(sinceRestart, bufferedEntry{startOffset, startLine,
+				append([]string(nil), lines...)})
+
+			for _, line := range lines {
+				sinceRestartBytes += int64(len(line)) + 1
+			}
+
+			// Degrades to also emitting the entries before the last
+			// restart, rather than running out of memory.
+			if sinceRestartBytes > SinceRestartMaxBytes || p.run.memoryPressure() {
+				if !flushedEarly {
+					fmt.Fprintf(os.Stderr, "warning: sinceLastRestart flushed its"+
+						" buffered entries early, file: %s/%s, entry: %d:%d\n",
+						p.dirBaseAnon, p.fnameAnon, startOffset, startLine)
+					flushedEarly = true
+				}
+
+				flushSinceRestart()
+			}
+		}
+	}
+
+	// Repeatably scan until we have the consecutive lines to make up
+	// an "entry", and invoke processEntry() on every entry.
+	var lineLen int64 // Length of the scanned line, including its newline.
+
+	var currOffset int64
+	var currLine int64
+
+	// When seeking, the scan starts at the byte before the seek offset,
+	// so the first scanned line, which is skipped, is either empty, when
+	// the offset is at a line start, or else the partial line.
+	var seeking bool // True while before the first entry after a Seek.
+	if seekOffset > 0 {
+		currOffset = seekOffset - 1
+		currLine = seekLine - 2
+
+		err = seekReader(f, currOffset)
+		if err != nil {
+			return err
+		}
+
+		seeking = true
+	}
+
+	var lineTruncated bool // True when the scanned line was oversized.
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, ScannerBufferCapacity)
+	scanner.Split(scanLinesTruncating(&lineLen, ScannerBufferCapacity, &lineTruncated))
+
+	// noteTruncated flags the current entry when its latest line was
+	// truncated, as the line would otherwise have stopped the scanner.
+	noteTruncated := func(entryStartOffset, entryStartLine int64) {
+		if lineTruncated {
+			fmt.Fprintf(os.Stderr, "warning: line longer than %d bytes was truncated,"+
+				" file: %s/%s, line: %d, entry: %d:%d\n", ScannerBufferCapacity,
+				p.dirBaseAnon, p.fnameAnon, currLine, entryStartOffset, entryStartLine)
+
+			p.truncated.add(entryStartOffset)
+		}
+	}
+
+	var entryStartOffset int64
+	var entryStartLine int64
+	var entryLines []string
+
+	var entryParsable bool // True when the current entry's first line is parsable.
+	var entryInQuote bool  // True when the current entry has an unclosed '"'.
+	var entryBraces int    // Depth of the current entry's unclosed '{'.
+
+	lineMode := p.run.LineMode || p.fmeta.LineMode
+
+	// True when a parsable line start was checked by the entry regexps,
+	// so the entry it starts counts as matched.
+	matchable := p.fmeta.EntryStart != nil || p.fmeta.EntryRE != nil
+
+	for scanner.Scan() {
+		lineStr := scanner.Text()
+
+		currLine++
+		if seekOffset <= 0 &&
+			currLine <= int64(p.fmeta.HeaderSize) { // Skip header.
+			if p.run.StrictHeader && currLine == int64(p.fmeta.HeaderSize) {
+				p.checkHeader(lineStr)
+			}
+
+			currOffset += lineLen
+			continue
+		}
+
+		if seeking && currOffset < seekOffset { // Skip the partial line.
+			// A blank-line delimited entry starts after an empty line.
+			seeking = !p.fmeta.BlankLineDelimited || lineStr != ""
+			currOffset += lineLen
+			continue
+		}
+
+		if p.run.StripANSI {
+			lineStr = stripANSI(lineStr)
+		}
+
+		if p.fmeta.BlankLineDelimited {
+			if seeking { // Re-sync to after the next blank line.
+				seeking = strings.TrimSpace(lineStr) != ""
+				currOffset += lineLen
+				continue
+			}
+
+			if strings.TrimSpace(lineStr) == "" {
+				handleEntry(entryStartOffset, entryStartLine, entryLines)
+
+				if stop() {
+					return nil
+				}
+
+				entryLines = nextEntryLines(entryLines, reuseLines)
+			} else {
+				if len(entryLines) <= 0 {
+					entryStartOffset = currOffset
+					entryStartLine = currLine
+
+					if p.fmeta.matchesEntry(lineStr) {
+						p.entriesMatched++
+					}
+				}
+
+				if p.fmeta.MaxEntryLines <= 0 || len(entryLines) < p.fmeta.MaxEntryLines {
+					entryLines = append(entryLines, lineStr)
+				}
+
+				noteTruncated(entryStartOffset, entryStartLine)
+			}
+
+			currOffset += lineLen
+			continue
+		}
+
+		// A line that fails the EntryStart or the EntryRE continues the
+		// current entry, unless the current entry is itself unparsable.
+		lineParsable := (p.fmeta.EntryStart == nil || p.fmeta.EntryStart(lineStr)) &&
+			(p.fmeta.EntryRE == nil || p.fmeta.matchesEntry(lineStr))
+
+		// A line with a time-only timestamp also starts an entry.
+		if !lineParsable && p.run.InferDate && re_time_only.MatchString(lineStr) {
+			lineParsable = true
+		}
+
+		// A line that starts inside a quoted string or inside braces of
+		// the current entry, like a message with a leading '[', or like
+		// a multi-line erlang term, continues it.
+		if lineParsable && (entryInQuote || entryBraces > 0) && entryParsable &&
+			len(entryLines) < MaxQuotedEntryLines {
+			lineParsable = false
+		}
+
+		if seeking { // Re-sync to the next entry start.
+			if !lineParsable && !lineMode {
+				currOffset += lineLen
+				continue
+			}
+			seeking = false
+		}
+
+		if lineMode || lineParsable || !entryParsable || len(entryLines) <= 0 {
+			handleEntry(entryStartOffset, entryStartLine, entryLines)
+
+			if stop() {
+				return nil
+			}
+
+			entryStartOffset = currOffset
+			entryStartLine = currLine
+			entryLines = nextEntryLines(entryLines, reuseLines)
+			entryParsable = lineParsable
+			entryInQuote = false
+			entryBraces = 0
+
+			if lineParsable && matchable {
+				p.entriesMatched++
+			}
+		}
+
+		if p.fmeta.MaxEntryLines <= 0 || len(entryLines) < p.fmeta.MaxEntryLines {
+			entryLines = append(entryLines, lineStr)
+		}
+
+		entryInQuote, entryBraces = inQuote(lineStr, entryInQuote, entryBraces)
+
+		noteTruncated(entryStartOffset, entryStartLine)
+
+		currOffset += lineLen
+	}
+
+	if !stop() {
+		handleEntry(entryStartOffset, entryStartLine, entryLines)
+	}
+
+	if !stop() {
+		flushSinceRestart()
+	}
+
+	if p.run.Tail > 0 && len(buffered) > p.run.Tail {
+		buffered = buffered[len(buffered)-p.run.Tail:]
+	}
+
+	for i := range buffered {
+		if p.run.Reverse {
+			i = len(buffered) - 1 - i
+		}
+
+		processEntry(buffered[i].startOffset, buffered[i].startLine, buffered[i].lines)
+		handled++
+
+		if stop() {
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
+
+// nextEntryLines returns the slice for the lines of the next entry,
+// which either reuses the backing array of the previous entry's lines,
+// to reduce garbage, or is nil, so that the previous entry's lines are
+// never overwritten while they're still referenced.
+func nextEntryLines(entryLines []string, reuse bool) []string {
+	if reuse {
+		return entryLines[0:0]
+	}
+	return nil
+}
+
+// seekReader moves a reader forwards to the offset, by seeking when
+// it's a seekable file, or else by reading and discarding.
+func seekReader(r io.Reader, offset int64) error {
+	if seeker, ok := r.(io.Seeker); ok {
+		_, err := seeker.Seek(offset, io.SeekStart)
+		return err
+	}
+
+	_, err := io.CopyN(ioutil.Discard, r, offset)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// checkHeader reports when the last line of the skipped header doesn't
+// match the header regexp, as when the header isn't HeaderSize lines.
+func (p *fileProcessor) checkHeader(line string) {
+	headerRE := p.fmeta.headerRE()
+	if headerRE == nil || headerRE.MatchString(line) {
+		return
+	}
+
+	p.run.m.Lock()
+	fmt.Fprintf(os.Stderr, "strictHeader: %s/%s, header line %d: %q,"+
+		" doesn't match: %s\n", p.dirBaseAnon, p.fnameAnon, p.fmeta.HeaderSize, line, headerRE)
+	p.run.m.Unlock()
+}
+
+// groupKey returns the key of the GroupBy group of an entry, like the
+// pid of an ns_server entry's header, or "" when it has none.
+func (p *fileProcessor) groupKey(firstLine string) string {
+	switch p.run.GroupBy {
+	case "pid":
+		// Ex: "[ns_server:info,2016-04-14T16:10:05.262-07:00,ns_1@127.0.0.1:<0.123.0>:..."
+		return ns_pid_re.FindString(firstLine)
+	}
+	return ""
+}
+
+// A bufferedEntry is an entry that's held back until the end of the
+// file for the Tail or Reverse params, or for the GroupBy param, with
+// its own copy of lines.
+type bufferedEntry struct {
+	startOffset, startLine int64
+	lines                  []string
+}
+
+// seekable returns true when the file can be scanned more than once,
+// and seeked, unlike a file of a bundle, of a URL or a gzip'ed file.
+func (p *fileProcessor) seekable() bool {
+	return p.bundled == nil && p.url == "" && !p.gzipped
+}
+
+// lastRestart is a first pass over a seekable file that returns the
+// offset and line number of the start of the last parsable entry at or
+// before the last line matching the restartRE, or 0, 0 when none does.
+func (p *fileProcessor) lastRestart() (int64, int64, error) {
+	f, err := p.open()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var lineLen int64
+	var lineTruncated bool
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, ScannerBufferCapacity)
+	scanner.Split(scanLinesTruncating(&lineLen, ScannerBufferCapacity, &lineTruncated))
+
+	lineMode := p.run.LineMode || p.fmeta.LineMode
+
+	var currOffset, currLine int64
+	var entryOffset, entryLine int64 // Start of the last parsable entry.
+	var restartOffset, restartLine int64
+
+	prevBlank := true // For a blank-line delimited entry's start.
+
+	for scanner.Scan() {
+		lineStr := scanner.Text()
+
+		currLine++
+		if currLine <= int64(p.fmeta.HeaderSize) {
+			currOffset += lineLen
+			continue
+		}
+
+		if p.run.StripANSI {
+			lineStr = stripANSI(lineStr)
+		}
+
+		if p.fmeta.BlankLineDelimited {
+			blank := strings.TrimSpace(lineStr) == ""
+			if prevBlank && !blank {
+				entryOffset, entryLine = currOffset, currLine
+			}
+			prevBlank = blank
+		} else if lineMode ||
+			((p.fmeta.EntryStart == nil || p.fmeta.EntryStart(lineStr)) &&
+				(p.fmeta.EntryRE == nil || p.fmeta.matchesEntry(lineStr))) ||
+			(p.run.InferDate && re_time_only.MatchString(lineStr)) {
+			entryOffset, entryLine = currOffset, currLine
+		}
+
+		if p.run.restartRE.MatchString(lineStr) {
+			restartOffset, restartLine = entryOffset, entryLine
+		}
+
+		currOffset += lineLen
+	}
+
+	return restartOffset, restartLine, scanner.Err()
+}
+
+// emitFileRecord emits a FILE record, before the file's entries, of
+// the file's resolved meta name, HeaderSize, size, mtime and dir.
+func (p *fileProcessor) emitFileRecord() {
+	p.run.m.Lock()
+	fsize := p.run.fileSizes[p.dirBase][p.fname]
+	p.run.m.Unlock()
+
+	ts := p.mtime.UTC().Format(tsLayout)
+
+	fields := fmt.Sprintf("meta=%q header_size=%d size=%d mtime=%q dir=%q",
+		fileMetaName(p.fname), p.fmeta.HeaderSize, fsize, ts, p.dirBaseAnon)
+
+	if p.url == "" && p.bundled == nil && p.fmeta.Tokenizer == "" && p.run.cbVersioned() {
+		lines, err := sniffLines(p.dir+string(os.PathSeparator)+p.fname, CBVersionLines)
+		if err == nil {
+			p.cbVersion, p.cbBuild = cbVersionOf(lines)
+		}
+	}
+
+	if p.cbVersion != "" {
+		fields += fmt.Sprintf(" cb_version=%q cb_build=%s", p.cbVersion, p.cbBuild)
+	}
+
+	module, ol := p.run.emitCommonPrep("", p.fnameBase, 0, 0)
+
+	p.emit(func() {
+		p.run.emitFileRecord(ts, module, p.dirBase, p.dirBaseAnon,
+			p.fname, p.fnameAnon, p.fnameOut, ol, fields)
+	})
+}
+
+// re_ansi matches ANSI escape sequences, such as CSI sequences like
+// "\x1b[1;31m" and OSC sequences like "\x1b]0;title\x07".
+var re_ansi = regexp.MustCompile(
+	`\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[@-_])`)
+
+// stripANSI removes any ANSI escape sequences from a line.
+func stripANSI(line string) string {
+	if strings.IndexByte(line, 0x1b) < 0 {
+		return line
+	}
+	return re_ansi.ReplaceAllString(line, "")
+}
+
+// scanLinesCounted returns a split func like bufio.ScanLines, which
+// also records the number of bytes that each returned line occupied
+// in the input, including any "\r\n" or "\n", into lineLen, so that
+// offsets are byte-accurate, even for a last line with no newline.
+func scanLinesCounted(lineLen *int64) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			*lineLen = int64(advance)
+		}
+		return advance, token, err
+	}
+}
+
+// scanLinesTruncating returns a split func like scanLinesCounted,
+// where a line that's longer than maxLen, which would otherwise stop
+// the scanner with bufio.ErrTooLong, is instead returned truncated to
+// its first maxLen bytes, while the rest of the line is read through
+// and discarded in buffer-sized chunks, so memory stays bounded, and
+// where truncated is set to whether the returned line was truncated.
+func scanLinesTruncating(lineLen *int64, maxLen int, truncated *bool) bufio.SplitFunc {
+	var head []byte     // The first maxLen bytes of an oversized line.
+	var discarded int64 // Count of the bytes discarded after the head.
+
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if head != nil {
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 && !atEOF {
+				discarded += int64(len(data))
+				return len(data), nil, nil
+			}
+
+			advance := len(data)
+			if i >= 0 {
+				advance = i + 1
+			}
+
+			token := head
+			head = nil
+
+			*lineLen = int64(len(token)) + discarded + int64(advance)
+			*truncated = true
+
+			return advance, token, nil
+		}
+
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token == nil && err == nil && !atEOF && len(data) >= maxLen {
+			head = append([]byte(nil), data[0:maxLen]...)
+			discarded = int64(len(data) - maxLen)
+			return len(data), nil, nil
+		}
+
+		if token != nil {
+			*lineLen = int64(advance)
+			*truncated = false
+		}
+
+		return advance, token, err
+	}
+}
+
+// truncatedEntries holds the start offsets of the entries of a file
+// that have a truncated, oversized line, which is shared by the
+// clones of an entryPipeline.
+type truncatedEntries struct {
+	m       sync.Mutex
+	offsets map[int64]bool
+}
+
+func (t *truncatedEntries) add(offset int64) {
+	t.m.Lock()
+	t.offsets[offset] = true
+	t.m.Unlock()
+}
+
+// take returns whether the entry at the offset has a truncated line,
+// forgetting the entry.
+func (t *truncatedEntries) take(offset int64) bool {
+	t.m.Lock()
+	rv := t.offsets[offset]
+	delete(t.offsets, offset)
+	t.m.Unlock()
+	return rv
+}
+
+// inQuote returns whether a double-quoted string is still open at the
+// end of the line, and the depth of the braces that are still open,
+// given those at its start, where backslash escaped quotes are ignored,
+// as are braces inside quoted strings and any unmatched closing braces.
+func inQuote(line string, quoted bool, braces int) (bool, int) {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case '{':
+			if !quoted {
+				braces++
+			}
+		case '}':
+			if !quoted && braces > 0 {
+				braces--
+			}
+		}
+	}
+	return quoted, braces
+}
+
+// teeReadCloser is a reader that copies what's read to a tee, while
+// closing the original reader.
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// teeFile is a buffered copy of the raw content of a file.
+type teeFile struct {
+	*bufio.Writer
+	f *os.File
+}
+
+func (t *teeFile) Close() error {
+	err := t.Writer.Flush()
+	if errC := t.f.Close(); err == nil {
+		err = errC
+	}
+	return err
+}
+
+// createTee creates the file of the TeeRaw directory that the raw, and
+// any gunzip'ed, content of the file is copied to, as a side effect of
+// parsing, like "teeRaw/cbcollect_n1/memcached.log".
+func (p *fileProcessor) createTee() (*teeFile, error) {
+	dir := p.run.TeeRaw + string(os.PathSeparator) + p.dirBase
+
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(dir + string(os.PathSeparator) + fileMetaName(p.fname))
+	if err != nil {
+		return nil, err
+	}
+
+	return &teeFile{Writer: bufio.NewWriter(f), f: f}, nil
+}
+
+// open returns a reader of the file, where the file might also be an
+// http or https URL or a file of a bundle, and which is gunzip'ed when
+// it looks gzip'ed.
+func (p *fileProcessor) open() (io.ReadCloser, error) {
+	if p.bundled != nil {
+		if p.bundledOpened {
+			return nil, fmt.Errorf("error: a file of a bundle can't be re-read,"+
+				" file: %s/%s", p.dirBaseAnon, p.fnameAnon)
+		}
+		p.bundledOpened = true
+
+		br := bufio.NewReader(p.bundled)
+
+		magic, _ := br.Peek(len(gzipMagic))
+		if strings.HasSuffix(p.fname, ".gz") || bytes.Equal(magic, gzipMagic) {
+			gz, err := gzip.NewReader(br)
+			if err != nil {
+				return nil, err
+			}
+
+			p.setGzipped()
+
+			return &gzipReadCloser{gz, ioutil.NopCloser(br)}, nil
+		}
+
+		return ioutil.NopCloser(br), nil
+	}
+
+	if p.url == "" {
+		f, err := os.Open(p.dir + string(os.PathSeparator) + p.fname)
+		if err != nil {
+			return nil, err
+		}
+
+		fi, err := f.Stat()
+		if err == nil {
+			p.mtime = fi.ModTime()
+		}
+
+		// A gzip'ed file, like a rotated "ns_server.debug.log.1.gz", is
+		// gunzip'ed, so the offsets are of the decompressed stream.
+		magic := make([]byte, 2)
+		n, _ := io.ReadFull(f, magic)
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		if strings.HasSuffix(p.fname, ".gz") ||
+			(n == len(gzipMagic) && bytes.Equal(magic, gzipMagic)) {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+
+			p.setGzipped()
+
+			return &gzipReadCloser{gz, f}, nil
+		}
+
+		return f, nil
+	}
+
+	resp, err := httpClient.Get(p.url)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("error: http get: %s, status: %s", p.url, resp.Status)
+	}
+
+	mtime, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err == nil {
+		p.mtime = mtime
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+
+	if strings.HasSuffix(p.fname, ".gz") ||
+		contentType == "application/gzip" ||
+		contentType == "application/x-gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+
+		p.setGzipped()
+
+		return &gzipReadCloser{gz, resp.Body}, nil
+	}
+
+	if resp.ContentLength > 0 {
+		p.run.m.Lock()
+		p.run.fileSizes[p.dirBase][p.fname] = resp.ContentLength
+		p.run.m.Unlock()
+	}
+
+	return resp.Body, nil
+}
+
+// setGzipped marks the file as gunzip'ed, as its size is then of the
+// compressed file, unlike its offsets.
+func (p *fileProcessor) setGzipped() {
+	p.gzipped = true
+
+	p.run.m.Lock()
+	p.run.gzipped[p.dirBase+"/"+p.fname] = true
+	p.run.m.Unlock()
+}
+
+// httpClient GETs the URL files, where the timeouts are on connecting
+// and on awaiting the response headers, but not on the whole response,
+// as a large log file might take a long while to download.
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+}
+
+// gzipMagic is the first bytes of a gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// gzipReadCloser closes both a gzip.Reader and its underlying source.
+type gzipReadCloser struct {
+	*gzip.Reader
+	c io.Closer
+}
+
+func (g *gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.c.Close()
+}
+
+func (p *fileProcessor) processEntry(startOffset, startLine int64, lines []string) {
+	if startLine <= 0 || len(lines) <= 0 {
+		return
+	}
+
+	if p.run.EmitOrig != "" {
+		linesJoined := strings.Join(p.run.anonymizeLines(lines), "\n")
+		if p.run.EmitOrig == "single" {
+			linesJoined = strings.Replace(linesJoined, "\n", " ", -1)
+		}
+
+		p.emit(func() {
+			p.run.m.Lock()
+			if !p.run.outputLimitedLocked() {
+				fmt.Fprintln(p.run.origOut, linesJoined)
+			}
+			p.run.m.Unlock()
+		})
+	}
+
+	p.explain = p.run.isExplained(startOffset, startLine)
+	if p.explain {
+		defer func() { p.explain = false }()
+
+		p.explainEntry(lines)
+	}
+
+	firstLine := lines[0]
+
+	if p.run.onlyModules != nil && p.fmeta.ModuleOf != nil {
+		module := p.run.caseModule(p.fmeta.ModuleOf(firstLine))
+		if module != "" && !p.run.onlyModules[module] {
+			if p.explain {
+				p.explainf("skipped module: %s", module)
+			}
+			return
+		}
+	}
+
+	if p.fmeta.Logfmt && p.processLogfmtEntry(startOffset, startLine, lines) {
+		return
+	}
+
+	entryRE, matchIndex := p.fmeta.matchEntry(firstLine)
+	if len(matchIndex) <= 0 && p.run.InferDate {
+		entryRE, matchIndex = re_time_only, re_time_only.FindStringSubmatchIndex(firstLine)
+	}
+	if p.explain {
+		p.explainMatch(firstLine, entryRE, matchIndex)
+	}
+	if len(matchIndex) <= 0 {
+		p.parseError(startOffset, startLine, lines, "no match")
+		return
+	}
+
+	if !p.run.TimestampTolerance && tsSloppy(entryRE, firstLine, matchIndex) {
+		p.parseError(startOffset, startLine, lines, "sloppy timestamp")
+		return
+	}
+
+	var ts string
+	if entryRE == re_time_only {
+		ts = p.inferDate(
+			string(entryRE.ExpandString(nil, "${HH}:${MM}:${SS}", firstLine, matchIndex)),
+			string(entryRE.ExpandString(nil, "${SSSS}", firstLine, matchIndex)))
+		if ts == "" {
+			p.parseError(startOffset, startLine, lines, "no date to infer")
+			return
+		}
+	} else {
+		ts = tsFit(string(entryRE.ExpandString(nil,
+			p.fmeta.tsTemplate(entryRE), firstLine, matchIndex)))
+
+		if p.run.InferDate {
+			if t, err := parseTS(ts); err == nil {
+				p.inferTS = t
+			}
+		}
+	}
+
+	if reason := badEntry(ts, lines); reason != "" {
+		p.parseError(startOffset, startLine, lines, reason)
+		return
+	}
+
+	module := string(entryRE.ExpandString(nil, "${module}", firstLine, matchIndex))
+
+	level := normalizeLevel(string(
+		entryRE.ExpandString(nil, "${level}", firstLine, matchIndex)))
+
+	var vals []entryVal
+
+	thread := string(entryRE.ExpandString(nil, "${thread}", firstLine, matchIndex))
+	if thread == "" && p.run.threadRE != nil {
+		thread = submatchNamedOrFirst(p.run.threadRE, "thread", firstLine)
+	}
+	if thread != "" {
+		vals = append(vals, entryVal{"thread", "STRING", thread})
+	}
+
+	if p.run.seqRE != nil {
+		if gap, ok := p.seqGap(module, firstLine); ok {
+			vals = append(vals, entryVal{"seq_gap", "INT", strconv.FormatInt(gap, 10)})
+		}
+	}
+
+	lines[0] = firstLine[matchIndex[1]:] // Strip off EntryRE's match.
+
+	// When the EntryRE consumed the entire first line, like a header
+	// line, the entry's body is pulled from its subsequent lines.
+	if len(lines) > 1 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+
+	p.processEntryParsed(startOffset, startLine, ts, module, level, lines, vals)
+}
+
+// From a cbcollect directory name...
+//
+//	cbcollect_info_ns_1@10.0.0.1_20160414-231044
+var re_node = regexp.MustCompile(`n(?:s_1|_\d+)@[A-Za-z0-9.:-]*[A-Za-z0-9]`)
+
+// nodeOf returns the node of a cbcollect directory, like
+// "ns_1@10.0.0.1", or else the dirBase itself.
+func nodeOf(dirBase string) string {
+	if node := re_node.FindString(dirBase); node != "" {
+		return node
+	}
+	return dirBase
+}
+
+// correlationID returns a stable id of an entry, from a hash of its
+// node, module and whitespace normalized message, rather than from
+// its offset and line, which differ between collections, so that the
+// same entry can be correlated across overlapping cbcollects.
+func correlationID(node, module string, lines []string) string {
+	h := fnv.New64a()
+	io.WriteString(h, node)
+	h.Write([]byte{0})
+	io.WriteString(h, module)
+	h.Write([]byte{0})
+	for _, line := range lines {
+		for _, field := range strings.Fields(line) {
+			io.WriteString(h, field)
+			h.Write([]byte{' '})
+		}
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// confidence scores how cleanly an entry was parsed, from 0.0 to 1.0,
+// where an unparsable timestamp, an unknown module or level,
+// unbalanced brackets and tokenizer errors lower the score from a
+// perfect 1.0.
+func (p *fileProcessor) confidence(ts string, unmatched bool, buf []byte) float64 {
+	score := 1.0
+
+	if _, err := parseTS(ts); err != nil || ts == tsNone {
+		score -= 0.25
+	}
+
+	if unmatched {
+		score -= 0.1
+	}
+
+	var s scanner.Scanner
+
+	fset := token.NewFileSet()
+
+	s.Init(fset.AddFile("", fset.Base(), len(buf)), buf, nil, 0)
+
+	var depth, unbalanced int
+	for {
+		_, tok, _ := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+
+		switch tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			if depth <= 0 {
+				unbalanced++ // A closer without an opener.
+			} else {
+				depth--
+			}
+		}
+	}
+	unbalanced += depth
+
+	if unbalanced > 0 {
+		score -= 0.25
+	}
+
+	score -= 0.05 * float64(s.ErrorCount)
+
+	if score < 0.0 {
+		score = 0.0
+	}
+
+	if p.explain {
+		p.explainf("confidence: %.2f, unbalanced: %d, tokenizer errors: %d",
+			score, unbalanced, s.ErrorCount)
+	}
+
+	return score
+}
+
+// seqGap parses the sequence number from an entry's first line,
+// returning the count of sequence numbers that were skipped since
+// the module's previous entry, or false when there was no skip.
+func (p *fileProcessor) seqGap(module, firstLine string) (int64, bool) {
+	seqStr := submatchNamedOrFirst(p.run.seqRE, "seq", firstLine)
+	if seqStr == "" {
+		return 0, false
+	}
+
+	seq, err := strconv.ParseInt(seqStr, p.run.SeqBase, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	if p.seqLasts == nil {
+		p.seqLasts = map[string]int64{}
+	}
+
+	last, exists := p.seqLasts[module]
+
+	p.seqLasts[module] = seq
+
+	if !exists || seq <= last+1 {
+		return 0, false // A seq that's not larger is a restart.
+	}
+
+	return seq - last - 1, true
+}
+
+// parseError handles an entry that can't be parsed, for a reason like
+// "no match", "bad timestamp" or "invalid utf-8", per the OnParseError
+// policy, where "skip" silently skips the entry.
+func (p *fileProcessor) parseError(startOffset, startLine int64,
+	lines []string, reason string) {
+	if p.explain {
+		p.explainf("parse error: %s, onParseError: %s", reason, p.run.onParseError)
+	}
+
+	switch p.run.onParseError {
+	case "emit":
+		p.processEntryUnparsed(startOffset, startLine, lines, reason)
+	case "abort":
+		log.Fatalf("error: parse error: %s, file: %s/%s, ol: %d:%d",
+			reason, p.dirBaseAnon, p.fnameAnon, startOffset, startLine)
+	}
+}
+
+// badEntry returns the reason that an entry with a parsed timestamp
+// is a parse error, "bad timestamp" or "invalid utf-8", or else "",
+// where the tsNone of a format's entry that has no timestamp is fine.
+func badEntry(ts string, lines []string) string {
+	if _, err := parseTS(ts); err != nil && ts != tsNone {
+		return "bad timestamp"
+	}
+
+	for _, line := range lines {
+		if !utf8.ValidString(line) {
+			return "invalid utf-8"
+		}
+	}
+
+	return ""
+}
+
+// processEntryUnparsed emits a placeholder for an entry that can't be
+// parsed, so the entry's lines are accounted for in the output.
+func (p *fileProcessor) processEntryUnparsed(startOffset, startLine int64,
+	lines []string, reason string) {
+	module, ol := p.run.emitCommonPrep("", p.fnameBase, startOffset, startLine)
+
+	lines = p.run.anonymizeLines(lines)
+
+	p.emitEntryFull(startOffset, startLine, ol, tsNone, module, "NONE", lines, "")
+	p.emitEntryVal(startOffset, startLine, ol, tsNone, module, "NONE",
+		"parse_failed", "INT", "1")
+	p.emitEntryVal(startOffset, startLine, ol, tsNone, module, "NONE",
+		"parse_error", "STRING", reason)
 }
 
-// A tokLit associates a token and a literal string.
-type tokLit struct {
-	tok     token.Token
-	lit     string
-	emitted bool // Marked true when this tokLit has been emitted.
+// An entryVal is a name=value that's parsed from an entry as a whole,
+// which is emitted as a VALS part right after the entry's FULL part.
+type entryVal struct {
+	name, valType, val string
 }
 
-// ------------------------------------------------------------
+// normalizeLevel converts a level like "[Info]" or "warning" into
+// an emitted level like "INFO" or "WARN".
+func normalizeLevel(level string) string {
+	level = strings.ToUpper(strings.Trim(level, "[]"))
+	if len(level) > 4 && level != "DEBUG" {
+		level = level[0:4]
+	}
+	return level
+}
 
-func (p *fileProcessor) process() error {
-	if p.run.ProgressEvery <= 0 {
-		fmt.Fprintf(os.Stderr, "processing %s/%s\n", p.dirBase, p.fname)
+// replayPace sleeps for the delta between the timestamps of the entry
+// and the previous entry, scaled by the ReplayDelay, where an entry
+// whose timestamp goes backwards or is unknown doesn't sleep.
+func (p *fileProcessor) replayPace(ts string) {
+	t, err := parseTS(ts)
+	if err != nil || ts == tsNone {
+		return
 	}
 
-	f, err := os.Open(p.dir + string(os.PathSeparator) + p.fname)
-	if err != nil {
-		return err
+	if !p.replayTS.IsZero() && t.After(p.replayTS) {
+		time.Sleep(time.Duration(float64(t.Sub(p.replayTS)) * p.run.ReplayDelay))
 	}
-	defer f.Close()
 
-	// Repeatably scan until we have the consecutive lines to make up
-	// an "entry", and invoke processEntry() on every entry.
-	scanner := bufio.NewScanner(f)
-	scanner.Buffer(nil, ScannerBufferCapacity)
+	p.replayTS = t
+}
 
-	var currOffset int64
-	var currLine int64
+// countLevel counts an entry's level in the LevelHistogram bucket of
+// the entry's timestamp, where entries without a timestamp are skipped.
+func (p *fileProcessor) countLevel(ts, level string) {
+	t, err := parseTS(ts)
+	if err != nil || ts == tsNone {
+		return
+	}
 
-	var entryStartOffset int64
-	var entryStartLine int64
-	var entryLines []string
+	bucket := t.Truncate(p.run.LevelHistogram).Format(tsLayout)
 
-	for scanner.Scan() {
-		lineStr := scanner.Text()
+	if p.levelCounts == nil {
+		p.levelCounts = map[string]map[string]int64{}
+	}
 
-		currLine++
-		if currLine <= int64(p.fmeta.HeaderSize) { // Skip header.
-			currOffset += int64(len(lineStr) + 1)
-			continue
-		}
+	counts := p.levelCounts[bucket]
+	if counts == nil {
+		counts = map[string]int64{}
+		p.levelCounts[bucket] = counts
+	}
 
-		if p.fmeta.EntryStart == nil || p.fmeta.EntryStart(lineStr) {
-			p.processEntry(entryStartOffset, entryStartLine, entryLines)
+	counts[level]++
+}
 
-			entryStartOffset = currOffset
-			entryStartLine = currLine
-			entryLines = entryLines[0:0]
+// addLevelCounts adds the src level counts, as of countLevel, to dst.
+func addLevelCounts(dst, src map[string]map[string]int64) {
+	for bucket, srcCounts := range src {
+		dstCounts := dst[bucket]
+		if dstCounts == nil {
+			dstCounts = map[string]int64{}
+			dst[bucket] = dstCounts
+		}
+		for level, n := range srcCounts {
+			dstCounts[level] += n
 		}
-
-		entryLines = append(entryLines, lineStr)
-		currOffset += int64(len(lineStr) + 1)
 	}
+}
 
-	p.processEntry(entryStartOffset, entryStartLine, entryLines)
+// levelRanks orders the normalized levels by severity.
+var levelRanks = map[string]int{
+	"DEBUG": 0,
+	"INFO":  1,
+	"NOTI":  2,
+	"WARN":  3,
+	"ERRO":  4,
+	"CRIT":  5,
+	"ALER":  6,
+	"EMER":  7,
+}
 
-	return scanner.Err()
+// levelRank returns the severity rank of a normalized level, or -1
+// for an unknown level, which is never filtered.
+func levelRank(level string) int {
+	rank, exists := levelRanks[level]
+	if !exists {
+		return -1
+	}
+	return rank
 }
 
-func (p *fileProcessor) processEntry(startOffset, startLine int64, lines []string) {
-	if startLine <= 0 || len(lines) <= 0 {
-		return
+// minLevelRank returns the rank of the higher of the Run's MinLevel
+// and the FileMeta's MinLevel, below which entries are skipped.
+func (p *fileProcessor) minLevelRank() int {
+	rank := levelRank(normalizeLevel(p.run.MinLevel))
+	if fmetaRank := levelRank(normalizeLevel(p.fmeta.MinLevel)); rank < fmetaRank {
+		rank = fmetaRank
 	}
+	return rank
+}
 
-	if p.run.EmitOrig != "" {
-		linesJoined := strings.Join(lines, "\n")
-		if p.run.EmitOrig == "single" {
-			linesJoined = strings.Replace(linesJoined, "\n", " ", -1)
-		}
+// processEntryParsed cleanses, filters, emits and tokenizes an entry
+// whose timestamp, module and level have already been parsed, where
+// the lines are the entry's content without any parsed prefix.
+func (p *fileProcessor) processEntryParsed(startOffset, startLine int64,
+	ts, module, level string, lines []string, vals []entryVal) {
+	var ol string // The ol looks like "offset:line".
 
-		p.run.m.Lock()
-		fmt.Println(linesJoined)
-		p.run.m.Unlock()
-	}
+	p.entriesParsed++
 
-	firstLine := lines[0]
+	if p.run.EmitCooccur != "" {
+		p.entryNames = p.entryNames[0:0]
+		defer func() { p.dict.AddCooccur(p.entryNames) }()
+	}
 
-	matchIndex := p.fmeta.EntryRE.FindStringSubmatchIndex(firstLine)
-	if len(matchIndex) <= 0 {
-		return
+	var services []string // From the endpoints, before any anonymizing.
+	if p.run.servicePorts != nil {
+		services = servicesOf(p.run.servicePorts, []byte(strings.Join(lines, "\n")))
 	}
 
-	ts := string(p.fmeta.EntryRE.ExpandString(nil,
-		"${year}-${month}-${day}T${HH}:${MM}:${SS}.${SSSS}", firstLine, matchIndex))
-	if len(ts) > len("2016-04-19T23:10:31.209") {
-		ts = ts[0:len("2016-04-19T23:10:31.209")]
+	lines = p.run.anonymizeLines(lines)
+
+	if module == "" {
+		module = p.fmeta.DefaultModule
+	}
+	if level == "" {
+		level = p.run.filenameLevels[p.fnameBase]
+	}
+	if level == "" {
+		level = normalizeLevel(p.fmeta.DefaultLevel)
 	}
 
-	module := string(p.fmeta.EntryRE.ExpandString(nil, "${module}", firstLine, matchIndex))
+	// An entry whose module or level is neither matched nor defaulted
+	// is less certain.
+	unmatched := module == "" || level == ""
 
-	level := string(p.fmeta.EntryRE.ExpandString(nil, "${level}", firstLine, matchIndex))
-	level = strings.ToUpper(strings.Trim(level, "[]"))
-	if len(level) > 4 && level != "DEBUG" {
-		level = level[0:4]
+	module, ol = p.run.emitCommonPrep(module, p.fnameBase, startOffset, startLine)
+
+	var rotationJump string // Non-"" when the ts went backwards.
+	if p.run.RotationThreshold > 0 {
+		t, err := parseTS(ts)
+		if err == nil {
+			if !p.lastTS.IsZero() && p.lastTS.Sub(t) > p.run.RotationThreshold {
+				rotationJump = formatRelTS(t.Sub(p.lastTS))
+			}
+			p.lastTS = t
+		}
 	}
 
-	lines[0] = firstLine[matchIndex[1]:] // Strip off EntryRE's match.
+	if p.run.onlyModules != nil && !p.run.onlyModules[module] {
+		return
+	}
 
-	var ol string // The ol looks like "offset:line".
+	if levelRank(level) < p.minLevelRank() {
+		return
+	}
+
+	if p.run.levels != nil && !p.run.levels[level] {
+		return
+	}
 
-	module, ol = emitCommonPrep(module, p.fnameBase, startOffset, startLine)
+	if p.run.LevelHistogram > 0 {
+		p.countLevel(ts, level)
+	}
 
-	p.run.emitEntryFull(ts, module, level, p.dirBase,
-		p.fname, p.fnameBase, p.fnameOut, ol, startOffset, startLine, lines)
+	if p.run.ReplayDelay > 0 {
+		p.replayPace(ts)
+	}
 
 	p.buf = p.buf[0:0]
 	for _, line := range lines {
@@ -143,21 +1484,229 @@ func (p *fileProcessor) processEntry(startOffset, startLine int64, lines []strin
 		p.buf = append(p.buf, '\n')
 	}
 
+	var requestID string // Non-"" when the entry has a request id.
+	if p.run.requestIDRE != nil {
+		requestID = p.requestID(vals, p.buf)
+	}
+
+	var latency string // Non-"" when the entry ends a known start.
+	if p.run.latencyStartRE != nil && p.run.latencyEndRE != nil {
+		latency = p.latencyMS(ts, p.buf)
+	}
+
+	var raw []byte // Uncleansed copy of the entry, when parsing JSON.
+	if p.run.ParseJSON || p.fmeta.ParseJSON {
+		raw = append(raw, p.buf...)
+	}
+
 	if p.fmeta.Cleanser != nil {
 		p.buf = p.fmeta.Cleanser(p.buf)
 	}
 
-	var s scanner.Scanner // Use go's tokenizer to parse the entry.
+	if p.explain {
+		p.explainTokens(p.buf)
+	}
+
+	if p.run.OnlyEntriesWithErrors && !hasErrorSignature(p.buf) {
+		return
+	}
+
+	if p.run.TraceID != "" {
+		if requestID != p.run.TraceID {
+			return
+		}
+		defer p.traceEntry(ts, startOffset)()
+	}
+
+	var confidence string // Non-"" with the Confidence param.
+	if p.run.Confidence {
+		confidence = strconv.FormatFloat(p.confidence(ts, unmatched, p.buf), 'f', 2, 64)
+	}
+
+	p.emitEntryFull(startOffset, startLine, ol, ts, module, level, lines, confidence)
+
+	for _, v := range vals {
+		p.emitEntryVal(startOffset, startLine, ol, ts, module, level,
+			v.name, v.valType, v.val)
+	}
+
+	var cbVersion, cbBuild string
+	if p.run.cbVersioned() {
+		cbVersion, cbBuild = cbVersionOf(lines)
+		if cbVersion == "" && p.run.AttachVersion {
+			cbVersion, cbBuild = p.cbVersion, p.cbBuild
+		}
+	}
+	if cbVersion != "" {
+		p.emitEntryVal(startOffset, startLine, ol, ts, module, level,
+			"cb_version", "STRING", cbVersion)
+		p.emitEntryVal(startOffset, startLine, ol, ts, module, level,
+			"cb_build", "INT", cbBuild)
+	}
+
+	if latency != "" {
+		p.emitEntryVal(startOffset, startLine, ol, ts, module, level,
+			"latency_ms", "INT", latency)
+	}
+
+	if p.truncated != nil && p.truncated.take(startOffset) {
+		p.emitEntryVal(startOffset, startLine, ol, ts, module, level,
+			"line_truncated_handled", "BOOL", "true")
+	}
+
+	if rotationJump != "" {
+		p.emitEntryVal(startOffset, startLine, ol, ts, module, level,
+			"rotation_boundary", "STRING", rotationJump)
+	}
+
+	for _, es := range p.run.eventSignatures {
+		if es.RE.Match(p.buf) {
+			p.emitEntryVal(startOffset, startLine, ol, ts, module, level,
+				"event_type", "STRING", es.EventType)
+		}
+	}
+
+	for i := range p.run.rules {
+		if p.run.rules[i].matches(module, level, p.buf) {
+			p.emitEntryVal(startOffset, startLine, ol, ts, module, level,
+				"tag", "STRING", p.run.rules[i].Tag)
+		}
+	}
+
+	if p.run.statusSignatures != nil {
+		p.emitEntryVal(startOffset, startLine, ol, ts, module, level,
+			"status", "STRING", statusOf(p.run.statusSignatures, p.buf))
+	}
+
+	if !p.run.timeOrigin.IsZero() {
+		t, err := parseTS(ts)
+		if err == nil {
+			p.emitEntryVal(startOffset, startLine, ol, ts, module, level,
+				"t_rel", "STRING", formatRelTS(t.Sub(p.run.timeOrigin)))
+		}
+	}
+
+	if p.run.CorrelationID {
+		p.emitEntryVal(startOffset, startLine, ol, ts, module, level,
+			"correlation_id", "STRING", correlationID(nodeOf(p.dirBase), module, lines))
+	}
+
+	for _, service := range services {
+		p.emitEntryVal(startOffset, startLine, ol, ts, module, level,
+			"service", "STRING", service)
+	}
+
+	if requestID != "" {
+		p.emitEntryVal(startOffset, startLine, ol, ts, module, level,
+			"request_id", "STRING", requestID)
+	}
+
+	if len(p.fmeta.Extractors) > 0 || len(p.run.extractors) > 0 {
+		emit := func(path []string, name, valType, val string) {
+			p.addDictEntry(valType, name, val)
+			p.emitEntryPart(startOffset, startLine, ol, ts, module, level,
+				"VALS", path, name, valType, val, valType == "STRING")
+		}
+
+		for _, extractor := range p.fmeta.Extractors {
+			p.buf = extractor(p, p.buf, emit)
+		}
+
+		for _, extractor := range p.run.extractors {
+			p.buf = extractor(p, p.buf, emit)
+		}
+	}
+
+	if raw != nil {
+		p.processEntryJSON(startOffset, startLine, ol, ts, module, level, raw)
+		return
+	}
+
+	p.tokenizeEntry(startOffset, startLine, ol, ts, module, level, p.buf)
+}
+
+// tokenizeEntry uses go's tokenizer to parse the buf of an entry.
+func (p *fileProcessor) tokenizeEntry(startOffset, startLine int64,
+	ol, ts, module, level string, buf []byte) {
+	if len(bytes.TrimSpace(buf)) <= 0 {
+		if p.explain {
+			p.explainf("empty body, not tokenized")
+		}
+		return // Skip an entry whose body is empty, like a header-only line.
+	}
+
+	var s scanner.Scanner
 
 	fset := token.NewFileSet()
 
 	s.Init(fset.AddFile(p.dir+string(os.PathSeparator)+p.fname,
-		fset.Base(), len(p.buf)), p.buf, nil /* No error handler. */, 0)
+		fset.Base(), len(buf)), buf, nil /* No error handler. */, 0)
+
+	p.tokDebug = p.run.TokenizerDebug || p.explain
+	if p.tokDebug {
+		defer func() { p.tokDebug = false }()
+	}
 
 	p.processEntryTokens(startOffset, startLine, ol, ts, module, level, &s,
 		make([]string, 0, 20))
 }
 
+// parseSkipTokens returns the skipToken set, with the tokens of a
+// comma-separated list, like "^,&" or "!<<", added, or removed when
+// prefixed by '!', where a list item that's itself a token, like "!"
+// or "!=", is added, so "! =" removes the "=" token.
+func parseSkipTokens(list string) (map[token.Token]bool, error) {
+	rv := map[token.Token]bool{}
+	for tok, skip := range skipToken {
+		rv[tok] = skip
+	}
+
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		tok, ok := lookupToken(s)
+
+		skip := true
+		if !ok && s[0] == '!' {
+			skip, s = false, strings.TrimSpace(s[1:])
+			tok, ok = lookupToken(s)
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown token: %q", s)
+		}
+
+		rv[tok] = skip
+	}
+
+	return rv, nil
+}
+
+// lookupToken returns the go token whose string is s, like "^".
+func lookupToken(s string) (token.Token, bool) {
+	for i := 0; i < 256; i++ {
+		if tok := token.Token(i); tok.String() == s {
+			return tok, true
+		}
+	}
+	return token.ILLEGAL, false
+}
+
+// skipTokensString returns the tokens of a skip set, like "<< >>".
+func skipTokensString(skipTokens map[token.Token]bool) string {
+	var toks []string
+	for tok, skip := range skipTokens {
+		if skip {
+			toks = append(toks, tok.String())
+		}
+	}
+	sort.Strings(toks)
+
+	return strings.Join(toks, " ")
+}
+
 // levelDelta tells us how some tokens affect our "depth" of nesting.
 var levelDelta = map[token.Token]int{
 	token.LPAREN: 1,
@@ -185,6 +1734,8 @@ var levelDelta = map[token.Token]int{
 	token.SEMICOLON: 0,
 }
 
+// skipToken is the default set of tokens that are dropped, where the
+// SkipTokens param adds to or removes from the set.
 var skipToken = map[token.Token]bool{
 	token.SHL: true, // <<
 	token.SHR: true, // >>
@@ -201,7 +1752,10 @@ func (p *fileProcessor) processEntryTokens(startOffset, startLine int64,
 			break
 		}
 
-		if skipToken[tok] {
+		if p.run.skipTokens[tok] {
+			if p.tokDebug {
+				p.tokDebugf(ol, path, "skip %s", tok)
+			}
 			continue
 		}
 
@@ -213,31 +1767,56 @@ func (p *fileProcessor) processEntryTokens(startOffset, startLine int64,
 				pathSub = append(pathSub, pathPart)
 			}
 
+			if p.tokDebug {
+				p.tokDebugf(ol, path, "open %s, levelDelta: %d, sub-path: %q", tok, delta, pathSub)
+			}
+
 			emitted = p.emitTokLits(startOffset, startLine, ol, ts, module, level,
 				path, tokLits, emitted)
 
-			// Recurse on nested sub-level.
-			p.processEntryTokens(startOffset, startLine, ol, ts, module, level, s, pathSub)
+			if depth := p.run.MaxPathDepth; depth > 0 &&
+				len(pathSub) > depth && p.deepVals == nil {
+				// Collect the too deep sub-level, to emit it as JSON.
+				p.deepVals = map[string]interface{}{}
+				p.processEntryTokens(startOffset, startLine, ol, ts, module, level, s, pathSub)
+				p.emitDeepVals(startOffset, startLine, ol, ts, module, level,
+					pathSub[0:depth], pathSub[depth])
+			} else {
+				// Recurse on nested sub-level.
+				p.processEntryTokens(startOffset, startLine, ol, ts, module, level, s, pathSub)
+			}
 		} else if delta < 0 {
+			if p.tokDebug {
+				p.tokDebugf(ol, path, "close %s, levelDelta: %d", tok, delta)
+			}
 			break // Return from nested sub-level recursion.
 		} else {
 			// If the token is merge'able with the previous token,
 			// then merge.  For example, we can merge an IDENT that's
 			// followed by a consecutive IDENT.
-			if !deltaExists && len(tokLits) > 0 {
+			if !deltaExists && len(tokLits) > 0 && !p.isBoolTokLit(tok, lit) {
 				tokLitPrev := tokLits[len(tokLits)-1]
-				if !tokLitPrev.emitted {
+				if !tokLitPrev.emitted && !p.isBoolTokLit(tokLitPrev.tok, tokLitPrev.lit) {
 					_, prevDeltaExists := levelDelta[tokLitPrev.tok]
 					if !prevDeltaExists {
 						tokLits[len(tokLits)-1].lit =
 							tokenLitString(tokLitPrev.tok, tokLitPrev.lit) + " " +
 								tokenLitString(tok, lit)
 
+						if p.tokDebug {
+							p.tokDebugf(ol, path, "merge %s(%q) into previous %s: %q",
+								tok, lit, tokLitPrev.tok, tokLits[len(tokLits)-1].lit)
+						}
 						continue
 					}
 				}
 			}
 
+			if p.tokDebug {
+				p.tokDebugf(ol, path, "token %s(%q), levelDelta: %d, in levelDelta: %t",
+					tok, lit, delta, deltaExists)
+			}
+
 			tokLits = append(tokLits, tokLit{tok, lit, false})
 		}
 	}
@@ -259,16 +1838,19 @@ func (p *fileProcessor) emitTokLits(startOffset, startLine int64,
 		tokLit.emitted = true
 
 		tokStr := tokLit.tok.String()
+		if p.isBoolTokLit(tokLit.tok, tokLit.lit) {
+			tokStr = "BOOL"
+		}
 
-		strs := strings.Trim(strings.Join(s, " "), "\t\n .:,")
-		p.run.emitEntryPart(ts, module, level, p.dirBase,
-			p.fname, p.fnameBase, p.fnameOut,
-			ol, startOffset, startLine,
-			"MIDS", path, "", "STRING", strs, true)
+		if p.pathFiltered(path, "") && p.deepVals == nil {
+			strs := strings.Trim(strings.Join(s, " "), "\t\n .:,")
+			p.emitEntryPart(startOffset, startLine, ol, ts, module, level,
+				"MIDS", path, "", "STRING", strs, true)
+		}
 
 		s = nil
 
-		name := cleanseName(nameFromTokLits(tokLits[0:i]))
+		name := cleanseName(nameFromTokLits(tokLits[0:i]), p.run.nameReject)
 		if name != "" {
 			namePath := path
 			if len(namePath) <= 0 {
@@ -277,23 +1859,233 @@ func (p *fileProcessor) emitTokLits(startOffset, startLine int64,
 				namePath = namePath[0 : len(namePath)-1]
 			}
 
-			if name != "" {
-				p.dict.AddDictEntry(tokStr, name, tokLit.lit)
-				p.run.emitEntryPart(ts, module, level, p.dirBase,
-					p.fname, p.fnameBase, p.fnameOut,
-					ol, startOffset, startLine,
-					"VALS", namePath, name, tokStr, tokLit.lit, false)
+			if p.tokDebug {
+				p.tokDebugf(ol, path, "emit %s(%q) as name: %q, name path: %q",
+					tokStr, tokLit.lit, name, namePath)
+			}
+
+			if name != "" && p.pathFiltered(namePath, name) {
+				p.addDictEntry(tokStr, name, tokLit.lit)
+
+				depth := p.run.MaxPathDepth
+				if p.deepVals != nil {
+					addDeepVal(p.deepVals, namePath[depth+1:], name, tokStr, tokLit.lit)
+				} else if depth > 0 && len(namePath) > depth {
+					p.deepVals = map[string]interface{}{}
+					addDeepVal(p.deepVals, namePath[depth+1:], name, tokStr, tokLit.lit)
+					p.emitDeepVals(startOffset, startLine, ol, ts, module, level,
+						namePath[0:depth], namePath[depth])
+				} else {
+					p.emitEntryPart(startOffset, startLine, ol, ts, module, level,
+						"VALS", namePath, name, tokStr, tokLit.lit, false)
+				}
 			}
 		}
 	}
 
-	strs := strings.Trim(strings.Join(s, " "), "\t\n .:,")
-	p.run.emitEntryPart(ts, module, level, p.dirBase, p.fname, p.fnameBase, p.fnameOut,
-		ol, startOffset, startLine, "ENDS", path, "", "STRING", strs, true)
+	if p.pathFiltered(path, "") && p.deepVals == nil {
+		strs := strings.Trim(strings.Join(s, " "), "\t\n .:,")
+		p.emitEntryPart(startOffset, startLine, ol, ts, module, level,
+			"ENDS", path, "", "STRING", strs, true)
+	}
 
 	return len(tokLits)
 }
 
+// emitDeepVals emits the collected deepVals, if any, as a VALS part
+// whose value is a JSON object, and stops the collecting.
+func (p *fileProcessor) emitDeepVals(startOffset, startLine int64,
+	ol, ts, module, level string, path []string, name string) {
+	deepVals := p.deepVals
+	p.deepVals = nil
+
+	if len(deepVals) <= 0 {
+		return
+	}
+
+	j, err := json.Marshal(deepVals)
+	if err != nil {
+		return
+	}
+
+	p.emitEntryPart(startOffset, startLine, ol, ts, module, level,
+		"VALS", path, name, "STRING", string(j), false)
+}
+
+// addDeepVal adds a tokenized value to the nested maps of deepVals,
+// at the path relative to the MaxPathDepth cut, where INT, FLOAT and
+// BOOL values keep their JSON types and STRING values are unquoted,
+// and other tokens, like IDENT's, are ignored.
+func addDeepVal(deepVals map[string]interface{}, path []string,
+	name, valType, val string) {
+	if valType != "INT" && valType != "FLOAT" &&
+		valType != "BOOL" && valType != "STRING" {
+		return
+	}
+
+	m := deepVals
+	for _, seg := range path {
+		sub, ok := m[seg].(map[string]interface{})
+		if !ok {
+			sub = map[string]interface{}{}
+			m[seg] = sub
+		}
+		m = sub
+	}
+
+	var v interface{} = val
+
+	switch valType {
+	case "INT", "FLOAT":
+		if i, err := strconv.ParseInt(val, 10, 64); err == nil {
+			v = i
+		} else if f, err := strconv.ParseFloat(val, 64); err == nil {
+			v = f
+		}
+	case "BOOL":
+		v = val == "true"
+	case "STRING":
+		if unquoted, err := strconv.Unquote(val); err == nil {
+			v = unquoted
+		}
+	}
+
+	m[name] = v
+}
+
+// pathFiltered returns true when there's no PathFilter param, or when
+// the path, followed by the optional name, starts with the PathFilter.
+func (p *fileProcessor) pathFiltered(path []string, name string) bool {
+	for i, f := range p.run.pathFilter {
+		if i < len(path) {
+			if path[i] != f {
+				return false
+			}
+		} else if i > len(path) || name != f {
+			return false
+		}
+	}
+	return true
+}
+
+// addDictEntry adds a value of a name to the file's dict, unless the
+// name is dropped, warning once when the dict is truncated by the
+// MaxDictSize.
+func (p *fileProcessor) addDictEntry(kind, name, val string) {
+	if p.run.dropNames[name] {
+		return
+	}
+
+	if p.run.EmitCooccur != "" {
+		p.entryNames = append(p.entryNames, name)
+	}
+
+	if !p.dict.AddDictEntry(kind, name, val, p.run.DictSamples, p.run.MaxDictSize) &&
+		!p.dictFull {
+		p.dictFull = true
+
+		// The clones of an entryPipeline instead leave the warning to
+		// the pipeline's finish, so it's only reported once.
+		if !p.captureEmits {
+			p.warnDictFull()
+		}
+	}
+}
+
+// warnDictFull reports that the file's dict was truncated.
+func (p *fileProcessor) warnDictFull() {
+	p.run.m.Lock()
+	fmt.Fprintf(os.Stderr, "maxDictSize reached, dictionary truncated: %s/%s\n",
+		p.dirBaseAnon, p.fnameAnon)
+	p.run.m.Unlock()
+}
+
+func (p *fileProcessor) emitEntryFull(startOffset, startLine int64,
+	ol, ts, module, level string, lines []string, confidence string) {
+	if p.explain {
+		p.explainf("emit FULL: %q", strings.Join(lines, "\n"))
+	}
+
+	p.emit(func() {
+		p.run.emitEntryFull(ts, module, level, p.dirBase, p.dirBaseAnon,
+			p.fname, p.fnameAnon, p.fnameBase, p.fnameOut, ol, startOffset, startLine, lines, confidence)
+	})
+}
+
+func (p *fileProcessor) emitEntryPart(startOffset, startLine int64,
+	ol, ts, module, level, partKind string,
+	namePath []string, name, valType, val string, valQuoted bool) {
+	if partKind == "VALS" && p.run.dropNames[name] {
+		return
+	}
+
+	if p.explain && len(val) > 0 {
+		p.explainf("emit %s: %+v %s = %s %s", partKind, namePath, name, valType, val)
+	}
+
+	// The namePath's backing array is reused by the tokenizer for the
+	// entry's next parts, so an emit that's invoked later, as when it's
+	// traced or captured, gets a copy.
+	if p.traced != nil || p.captureEmits {
+		namePath = append([]string(nil), namePath...)
+	}
+
+	p.emit(func() {
+		p.run.emitEntryPart(ts, module, level, p.dirBase, p.dirBaseAnon,
+			p.fname, p.fnameAnon, p.fnameBase, p.fnameOut,
+			ol, startOffset, startLine,
+			partKind, namePath, name, valType, val, valQuoted)
+	})
+}
+
+// emit invokes the emit func, unless the emits of the fileProcessor
+// are being captured, as in an entryPipeline or for the TraceID param,
+// to be invoked later.
+func (p *fileProcessor) emit(f func()) {
+	if p.traced != nil {
+		p.traced.emits = append(p.traced.emits, f)
+		return
+	}
+	if p.captureEmits {
+		p.emits = append(p.emits, f)
+		return
+	}
+	f()
+}
+
+// emitEntryVal emits a name=value VALS part that's derived from the
+// entry as a whole, rather than from its tokens.
+func (p *fileProcessor) emitEntryVal(startOffset, startLine int64,
+	ol, ts, module, level, name, valType, val string) {
+	p.addDictEntry(valType, name, val)
+	p.emitEntryPart(startOffset, startLine, ol, ts, module, level,
+		"VALS", nil, name, valType, val, valType == "STRING")
+}
+
+// submatchNamedOrFirst returns the submatch of the named group of re,
+// or of re's first group when re has no such named group.
+func submatchNamedOrFirst(re *regexp.Regexp, name, s string) string {
+	m := re.FindStringSubmatch(s)
+	if len(m) <= 1 {
+		return ""
+	}
+
+	for i, n := range re.SubexpNames() {
+		if n == name {
+			return m[i]
+		}
+	}
+
+	return m[1]
+}
+
+// isBoolTokLit returns true for a true or false IDENT, when the
+// BoolVals param is used, where it's kept as a BOOL value, rather than
+// being merged into neighboring tokens.
+func (p *fileProcessor) isBoolTokLit(tok token.Token, lit string) bool {
+	return p.run.BoolVals && tok == token.IDENT && (lit == "true" || lit == "false")
+}
+
 // nameFromTokLits returns the last IDENT or STRING from the tokLits,
 // which the caller can use as a name.
 func nameFromTokLits(tokLits []tokLit) string {
@@ -307,10 +2099,11 @@ func nameFromTokLits(tokLits []tokLit) string {
 }
 
 // cleanseName cleans up a name string, using heuristic rules;
-// otherwise, returns "" for an invalid name.
-func cleanseName(name string) string {
+// otherwise, returns "" for an invalid name, including names that
+// match the optional reject regexp.
+func cleanseName(name string, reject *regexp.Regexp) string {
 	name = strings.Trim(name, " \t\n\"")
-	if strings.IndexAny(name, "<>/ ") >= 0 ||
+	if (reject != nil && reject.MatchString(name)) ||
 		name == "true" || name == "false" ||
 		name == "ok" || name == "pid" || name == "uuid" ||
 		strings.HasPrefix(name, "0x") || re_int.MatchString(name) {
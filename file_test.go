@@ -0,0 +1,356 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSinceLastRestart(t *testing.T) {
+	out := runFixture(t, "-sinceLastRestart", "-emitParts", "FULL",
+		"testdata/restart")
+
+	if strings.Contains(out, "curr_items=1") ||
+		strings.Contains(out, "curr_items=2") ||
+		!strings.Contains(out, "Restarting file logging") ||
+		!strings.Contains(out, "curr_items=3") {
+		t.Errorf("expected only the entries from the last restart, got:\n%s", out)
+	}
+
+	if n := strings.Count(out, "\n"); n != 2 {
+		t.Errorf("expected 2 entries, got: %d, out:\n%s", n, out)
+	}
+}
+
+func TestFileRecordPrecedesEntries(t *testing.T) {
+	out := runFixture(t, "-workers", "4", "-emitParts", "FILE,FULL",
+		"testdata/nested", "testdata/restart")
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 8 {
+		t.Fatalf("expected 2 FILE records and 6 entries, got:\n%s", out)
+	}
+
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if fields[1] != "FILE" {
+			continue
+		}
+		if fields[3] != "0:0" {
+			t.Errorf("expected a FILE record at 0:0, got: %q", line)
+		}
+		if i+1 >= len(lines) || strings.Fields(lines[i+1])[2] != fields[2] {
+			t.Errorf("expected the FILE record to precede its entries, got:\n%s", out)
+		}
+	}
+}
+
+func TestEntriesMatchedBeforeFiltering(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mortimint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The entries of a bundle's file are all scanned, unlike the entries
+	// before the last restart of a dir's file, which are seeked past.
+	run, _ := parseArgsToRun([]string{"mortimint", "-failOnNoMatch",
+		"-sinceLastRestart", "-emitParts", "FULL", restartBundle(t, dir)})
+	run.processDirs()
+
+	fp := run.fileProcessors["restart"]["memcached.log"]
+	if fp.entriesMatched != 5 || fp.entriesParsed != 2 {
+		t.Errorf("expected 5 matched and 2 parsed entries, got: %d, %d",
+			fp.entriesMatched, fp.entriesParsed)
+	}
+}
+
+func TestBoolVals(t *testing.T) {
+	out := runFixture(t, "-emitParts", "VALS", "-emitTypes", "INT,BOOL,IDENT",
+		"testdata/bools")
+	expectLines(t, out,
+		"2016-04-14T16:10:09.463 NOTI bools/memcached.log 12:5 memcached bucket enabled = IDENT true")
+
+	out = runFixture(t, "-boolVals", "-emitParts", "VALS", "-emitTypes", "INT,BOOL",
+		"testdata/bools")
+	expectLines(t, out,
+		"2016-04-14T16:10:09.463 NOTI bools/memcached.log 12:5 memcached bucket enabled = BOOL true",
+		"2016-04-14T16:10:09.463 NOTI bools/memcached.log 12:5 memcached bucket warm = BOOL false",
+		"2016-04-14T16:10:09.463 NOTI bools/memcached.log 12:5 memcached bucket items = INT 3")
+}
+
+func TestInQuote(t *testing.T) {
+	tests := []struct {
+		line           string
+		quoted         bool
+		braces         int
+		expectedQuoted bool
+		expectedBraces int
+	}{
+		{`a "b`, false, 0, true, 0},
+		{`a \"b" c`, true, 0, false, 0},
+		{`{a, {b, "}"`, false, 0, false, 2},
+		{`}} x }`, false, 2, false, 0},
+		{`"{" }`, false, 1, false, 0},
+	}
+
+	for _, test := range tests {
+		quoted, braces := inQuote(test.line, test.quoted, test.braces)
+		if quoted != test.expectedQuoted || braces != test.expectedBraces {
+			t.Errorf("inQuote(%q, %t, %d): %t, %d, expected: %t, %d",
+				test.line, test.quoted, test.braces, quoted, braces,
+				test.expectedQuoted, test.expectedBraces)
+		}
+	}
+}
+
+func TestEntryStartInsideBraces(t *testing.T) {
+	out := runFixture(t, "-emitParts", "FULL", "testdata/braces")
+
+	if n := strings.Count(out, "\n"); n != 2 {
+		t.Errorf("expected 2 entries, got: %d, out:\n%s", n, out)
+	}
+}
+
+func TestNoTrailingNewlineOffsets(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/nonewline/memcached.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lineLen, sum int64
+	var truncated bool
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Split(scanLinesTruncating(&lineLen, ScannerBufferCapacity, &truncated))
+	for scanner.Scan() {
+		sum += lineLen
+	}
+	if sum != int64(len(data)) {
+		t.Errorf("expected the line lengths to sum to %d bytes, got: %d", len(data), sum)
+	}
+
+	out := runFixture(t, "-emitParts", "FULL", "testdata/nonewline")
+
+	for i, e := range []struct{ ss, msg string }{
+		{"09", "first"}, {"10", "second"}, {"11", "last"},
+	} {
+		offset := bytes.Index(data, []byte("2016-04-14T16:10:"+e.ss))
+		expectLines(t, out, fmt.Sprintf("2016-04-14T16:10:%s.463 WARN"+
+			" nonewline/memcached.log %d:%d memcached %s curr_items=%d",
+			e.ss, offset, i+5, e.msg, i+1))
+	}
+}
+
+func TestOnParseErrorDefaultIsSkip(t *testing.T) {
+	out := runFixture(t, "-emitParts", "FULL,VALS", "-emitTypes", "INT,STRING",
+		"testdata/parseerrors")
+	outSkip := runFixture(t, "-onParseError", "skip", "-emitParts", "FULL,VALS",
+		"-emitTypes", "INT,STRING", "testdata/parseerrors")
+	if out != outSkip {
+		t.Errorf("expected the default to be like skip, got:\n%s\nvs skip:\n%s", out, outSkip)
+	}
+
+	out = runFixture(t, "-onParseError", "emit", "-emitParts", "VALS",
+		"-emitTypes", "STRING", "testdata/parseerrors")
+
+	for _, exp := range []string{
+		`parseerrors/master_events.log 46:2 master_events parse_error = STRING "not an object"`,
+		`parseerrors/master_events.log 49:3 master_events parse_error = STRING "bad timestamp"`,
+		`parseerrors/memcached.log 71:6 memcached parse_error = STRING "invalid utf-8"`,
+		`parseerrors/ns_server.query.log 84:6 query parse_error = STRING "bad timestamp"`,
+		`parseerrors/ns_server.xdcr_trace.log 91:6 xdcr_trace parse_error = STRING "bad json"`,
+		`parseerrors/ns_server.xdcr_trace.log 116:7 xdcr_trace parse_error = STRING "not an object"`,
+		`parseerrors/ns_server.xdcr_trace.log 119:8 xdcr_trace parse_error = STRING "bad timestamp"`,
+	} {
+		if !strings.Contains(strings.Join(strings.Fields(out), " "), exp) {
+			t.Errorf("expected: %s, got:\n%s", exp, out)
+		}
+	}
+}
+
+func TestParseSkipTokens(t *testing.T) {
+	for _, c := range []struct {
+		list          string
+		skip, notSkip []token.Token
+	}{
+		{"^,&", []token.Token{token.XOR, token.AND, token.SHL, token.SHR}, nil},
+		{"--,-=", []token.Token{token.DEC, token.SUB_ASSIGN, token.SHL}, nil},
+		{"!<<", []token.Token{token.SHR}, []token.Token{token.SHL}},
+		{"!,!=", []token.Token{token.NOT, token.NEQ}, nil},
+		{"=,! =", nil, []token.Token{token.ASSIGN}},
+	} {
+		skipTokens, err := parseSkipTokens(c.list)
+		if err != nil {
+			t.Fatalf("list: %q, err: %v", c.list, err)
+		}
+		for _, tok := range c.skip {
+			if !skipTokens[tok] {
+				t.Errorf("list: %q, expected skipped: %s", c.list, tok)
+			}
+		}
+		for _, tok := range c.notSkip {
+			if skipTokens[tok] {
+				t.Errorf("list: %q, expected not skipped: %s", c.list, tok)
+			}
+		}
+	}
+
+	if _, err := parseSkipTokens("!bogus"); err == nil {
+		t.Errorf("expected an unknown token err")
+	}
+}
+
+func TestGzippedSizes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mortimint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data, err := ioutil.ReadFile("testdata/levels/memcached.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(data)
+	w.Close()
+
+	fname := "memcached.log.1.gz"
+	if err = ioutil.WriteFile(filepath.Join(dir, fname), gz.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The compressed size isn't the size of the uncompressed entries.
+	run, _ := parseArgsToRun([]string{"mortimint", "-reverse", dir})
+	fp := run.newFileProcessor(dir, filepath.Base(dir), fname, FileMetas["memcached.log"])
+	if err = fp.process(); err == nil || !strings.Contains(err.Error(), "reverse needs a tail") {
+		t.Errorf("expected reverse to need a tail for a gzip'ed file, got: %v", err)
+	}
+
+	run, _ = parseArgsToRun([]string{"mortimint", dir})
+
+	var out bytes.Buffer
+	run.addEmitter(run.EmitParts, run.EmitTypes, run.EmitFormat, &out)
+
+	run.EntryCallback = func(entry Entry) {
+		// The progress of a gzip'ed file isn't of its compressed size.
+		if progress := run.fileProgress[entry.Dir][fname]; progress != 0 {
+			t.Errorf("expected no progress until done, got: %d", progress)
+		}
+	}
+
+	run.processDirs()
+
+	if strings.Count(out.String(), "curr_items=") != 3 {
+		t.Errorf("expected the gunzip'ed entries, got:\n%s", out.String())
+	}
+
+	base := filepath.Base(dir)
+	if run.fileProgress[base][fname] != run.fileSizes[base][fname] {
+		t.Errorf("expected the progress to be done, got: %d, size: %d",
+			run.fileProgress[base][fname], run.fileSizes[base][fname])
+	}
+}
+
+// restartBundle writes a bundle of the restart fixture into dir.
+func restartBundle(t *testing.T, dir string) string {
+	data, err := ioutil.ReadFile("testdata/restart/memcached.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "restart/memcached.log", Mode: 0600,
+		Size: int64(len(data)), Typeflag: tar.TypeReg})
+	tw.Write(data)
+	tw.Close()
+	gw.Close()
+
+	bundle := filepath.Join(dir, "cbcollect_info-20160414-231044.tar.gz")
+	if err = ioutil.WriteFile(bundle, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return bundle
+}
+
+func TestSinceLastRestartBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mortimint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bundle := restartBundle(t, dir)
+
+	// A file of a bundle can only be read once, so its entries since
+	// the last restart are buffered, rather than seeked to, as for the
+	// file of a dir.
+	exp := runFixture(t, "-sinceLastRestart", "-emitParts", "FULL", "testdata/restart")
+	out := runFixture(t, "-sinceLastRestart", "-emitParts", "FULL", bundle)
+	if out != exp || !strings.Contains(out, "curr_items=3") {
+		t.Errorf("expected the same as the dir:\n%s\ngot:\n%s", exp, out)
+	}
+}
+
+func TestSinceLastRestartFlushedEarly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mortimint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bundle := restartBundle(t, dir)
+
+	defer func(n int64) { SinceRestartMaxBytes = n }(SinceRestartMaxBytes)
+	SinceRestartMaxBytes = 1
+
+	// The buffered entries are emitted as they exceed the cap, so the
+	// entries before the last restart are emitted too.
+	out := runFixture(t, "-sinceLastRestart", "-emitParts", "FULL", bundle)
+	for _, s := range []string{"curr_items=1", "curr_items=2", "curr_items=3"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected %s, got:\n%s", s, out)
+		}
+	}
+}
+
+func TestEmitOrigMaxOutputBytes(t *testing.T) {
+	run, _ := parseArgsToRun([]string{"mortimint", "-emitOrig", "single",
+		"-maxOutputBytes", "1", "testdata/restart"})
+
+	var out bytes.Buffer
+	run.origOut = &countWriter{w: &out, n: &run.emitBytes}
+
+	run.processDirs()
+
+	// The first entry reaches the maxOutputBytes, which stops the rest.
+	if n := strings.Count(out.String(), "\n"); n != 1 || run.emitBytes != int64(out.Len()) {
+		t.Errorf("expected 1 counted entry, got: %d, %d bytes, out:\n%s",
+			n, run.emitBytes, out.String())
+	}
+}
@@ -0,0 +1,188 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// From systemd_journal.gz, which is in the journal export format,
+// where records are separated by a blank line...
+//   __REALTIME_TIMESTAMP=1460675405262000
+//   PRIORITY=6
+//   SYSLOG_IDENTIFIER=couchbase-server
+//   _PID=1234
+//   MESSAGE=Started Couchbase Server.
+//
+// A binary field, such as a MESSAGE with control characters, is
+// instead a line of just the field name, followed by the field's
+// size as a little-endian uint64, followed by the field's data and
+// a newline.
+
+// journalLevels maps a journal PRIORITY to a level.
+var journalLevels = []string{
+	"EMERG", "ALERT", "CRIT", "ERROR", "WARNING", "NOTICE", "INFO", "DEBUG",
+}
+
+// processJournal reads the records of a journal export stream, which
+// is gunzip'ed when it looks gzip'ed.
+func (p *fileProcessor) processJournal(r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	magic, _ := br.Peek(2)
+	if bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+
+		br = bufio.NewReader(gz)
+	}
+
+	var currOffset int64
+	var currLine int64
+
+	var recordStartOffset int64
+	var recordStartLine int64 = 1
+	var fields [][2]string
+
+	for {
+		lineStr, err := br.ReadString('\n')
+		if len(lineStr) <= 0 && err != nil {
+			p.processJournalRecord(recordStartOffset, recordStartLine, fields)
+
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		currOffset += int64(len(lineStr))
+		currLine++
+
+		lineStr = strings.TrimSuffix(lineStr, "\n")
+		if lineStr == "" {
+			p.processJournalRecord(recordStartOffset, recordStartLine, fields)
+
+			recordStartOffset = currOffset
+			recordStartLine = currLine + 1
+			fields = fields[0:0]
+
+			continue
+		}
+
+		eq := strings.IndexByte(lineStr, '=')
+		if eq >= 0 {
+			fields = append(fields, [2]string{lineStr[0:eq], lineStr[eq+1:]})
+			continue
+		}
+
+		// A binary field.
+		var size uint64
+		err = binary.Read(br, binary.LittleEndian, &size)
+		if err != nil {
+			return err
+		}
+		if size > uint64(ScannerBufferCapacity) {
+			return fmt.Errorf("error: journal field %s too big, size: %d", lineStr, size)
+		}
+
+		data := make([]byte, size+1) // Includes the trailing newline.
+		_, err = io.ReadFull(br, data)
+		if err != nil {
+			return err
+		}
+
+		currOffset += 8 + int64(len(data))
+		currLine += int64(bytes.Count(data, []byte("\n")))
+
+		fields = append(fields, [2]string{lineStr, string(data[0:size])})
+	}
+}
+
+// processJournalRecord emits a journal record as an entry, using the
+// __REALTIME_TIMESTAMP, PRIORITY, SYSLOG_IDENTIFIER and MESSAGE fields,
+// where the remaining fields are emitted as VALS parts.
+func (p *fileProcessor) processJournalRecord(startOffset, startLine int64,
+	fields [][2]string) {
+	if len(fields) <= 0 {
+		return
+	}
+
+	var ts, module, level, message string
+	var vals []entryVal
+
+	for _, field := range fields {
+		name, val := field[0], field[1]
+
+		switch name {
+		case "__REALTIME_TIMESTAMP":
+			usecs, err := strconv.ParseInt(val, 10, 64)
+			if err == nil {
+				ts = time.Unix(usecs/1000000, (usecs%1000000)*1000).
+					UTC().Format(tsLayout)
+			}
+
+		case "PRIORITY":
+			priority, err := strconv.Atoi(val)
+			if err == nil && priority >= 0 && priority < len(journalLevels) {
+				level = normalizeLevel(journalLevels[priority])
+			}
+
+		case "SYSLOG_IDENTIFIER":
+			module = val
+
+		case "MESSAGE":
+			message = val
+
+		default:
+			if _, err := strconv.ParseInt(val, 10, 64); err == nil {
+				vals = append(vals, entryVal{name, "INT", val})
+			} else {
+				vals = append(vals, entryVal{name, "STRING", val})
+			}
+		}
+	}
+
+	p.explain = p.run.isExplained(startOffset, startLine)
+	if p.explain {
+		defer func() { p.explain = false }()
+
+		p.explainf("journal record, fields: %q", fields)
+	}
+
+	reason := "bad timestamp"
+	if ts != "" {
+		reason = badEntry(ts, []string{message})
+	}
+	if reason != "" {
+		lines := make([]string, 0, len(fields))
+		for _, field := range fields {
+			lines = append(lines, field[0]+"="+field[1])
+		}
+
+		p.parseError(startOffset, startLine, lines, reason)
+		return
+	}
+
+	p.processEntryParsed(startOffset, startLine, ts, module, level,
+		[]string{message}, vals)
+}
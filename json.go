@@ -0,0 +1,154 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// processEntryJSON emits the leaf values of any JSON objects found in
+// the raw, uncleansed buf of an entry, and cleanses and tokenizes the
+// text before, between and after those JSON objects as usual.
+//
+// For example, from projector...
+//
+//	memstats {"Alloc":79226592, "Sys":12} (took 2ms)
+//
+// The text "memstats" and "(took 2ms)" are tokenized, and the JSON
+// object is emitted as [memstats] Alloc = INT 79226592 and
+// [memstats] Sys = INT 12.
+func (p *fileProcessor) processEntryJSON(startOffset, startLine int64,
+	ol, ts, module, level string, buf []byte) {
+	for len(buf) > 0 {
+		start, end, v := findJSON(buf)
+		if start < 0 {
+			break
+		}
+
+		prefix := p.cleanse(buf[0:start])
+
+		p.tokenizeEntry(startOffset, startLine, ol, ts, module, level, prefix)
+
+		var path []string
+
+		prefixFields := bytes.Fields(prefix)
+		if len(prefixFields) > 0 {
+			name := cleanseName(string(prefixFields[len(prefixFields)-1]),
+				p.run.nameReject)
+			if name != "" {
+				path = []string{name}
+			}
+		}
+
+		p.emitJSON(startOffset, startLine, ol, ts, module, level, path, "", v)
+
+		buf = buf[end:]
+	}
+
+	p.tokenizeEntry(startOffset, startLine, ol, ts, module, level, p.cleanse(buf))
+}
+
+// cleanse returns a cleansed copy of a part of an entry.
+func (p *fileProcessor) cleanse(buf []byte) []byte {
+	buf = append([]byte(nil), buf...)
+	if p.fmeta.Cleanser != nil {
+		buf = p.fmeta.Cleanser(buf)
+	}
+	return buf
+}
+
+// findJSON returns the start and end offsets of the first JSON object
+// in buf, or -1 when there's none.  A streaming decoder is used, which
+// stops at the end of the first JSON value, so any trailing text after
+// a JSON object is not an error.
+func findJSON(buf []byte) (int, int, interface{}) {
+	for i := bytes.IndexByte(buf, '{'); i >= 0; {
+		dec := json.NewDecoder(bytes.NewReader(buf[i:]))
+		dec.UseNumber()
+
+		var v map[string]interface{}
+		if dec.Decode(&v) == nil {
+			return i, i + int(dec.InputOffset()), v
+		}
+
+		next := bytes.IndexByte(buf[i+1:], '{')
+		if next < 0 {
+			break
+		}
+		i += 1 + next
+	}
+
+	return -1, -1, nil
+}
+
+// emitJSON recursively emits the leaf values of a decoded JSON value
+// as VALS parts, where nested JSON objects extend the path.
+func (p *fileProcessor) emitJSON(startOffset, startLine int64,
+	ol, ts, module, level string, path []string, name string, v interface{}) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		if name != "" {
+			path = append(path[0:len(path):len(path)], name)
+		}
+
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			p.emitJSON(startOffset, startLine, ol, ts, module, level,
+				path, cleanseName(k, p.run.nameReject), x[k])
+		}
+
+	case []interface{}:
+		for _, xv := range x {
+			p.emitJSON(startOffset, startLine, ol, ts, module, level,
+				path, name, xv)
+		}
+
+	default:
+		if name == "" {
+			return
+		}
+
+		valType, val := jsonLeafTypeVal(x)
+		if valType == "BOOL" && !p.run.BoolVals {
+			valType = "IDENT"
+		}
+
+		p.addDictEntry(valType, name, val)
+		p.emitEntryPart(startOffset, startLine, ol, ts, module, level,
+			"VALS", path, name, valType, val, false)
+	}
+}
+
+// jsonLeafTypeVal returns the token type and literal for a leaf JSON
+// value, in the same form that the tokenizer would have emitted.
+func jsonLeafTypeVal(v interface{}) (string, string) {
+	switch x := v.(type) {
+	case json.Number:
+		if _, err := x.Int64(); err == nil {
+			return "INT", x.String()
+		}
+		return "FLOAT", x.String()
+	case string:
+		return "STRING", strconv.Quote(x)
+	case bool:
+		return "BOOL", strconv.FormatBool(x)
+	}
+	return "IDENT", "null"
+}
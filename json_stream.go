@@ -0,0 +1,247 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// From master_events.log, where the JSON objects are concatenated,
+// one after another, whether on a single line or pretty-printed
+// across lines, without any blank line separators...
+//   {"type":"rebalanceStart","ts":1460675406.262,"nodes":["ns_1@10.0.0.1"]}
+//   {
+//     "type": "vbucketMoveStart",
+//     "ts": 1460675406.318,
+//     "bucket": "default",
+//     "vbucket": 22
+//   }
+
+// jsonStreamTSKeys are the fields of a JSON object whose value is
+// the object's timestamp, in the order they're tried.
+var jsonStreamTSKeys = []string{"ts", "time", "timestamp"}
+
+// processJSONStream reads the successive JSON values of a file, where
+// every JSON object is an entry, regardless of its line boundaries.
+func (p *fileProcessor) processJSONStream(r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	var headerOffset int64
+
+	for i := 0; i < p.fmeta.HeaderSize; i++ {
+		lineStr, err := br.ReadString('\n')
+		headerOffset += int64(len(lineStr))
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+
+	lc := &lineCounter{r: br, offset: headerOffset, lines: int64(p.fmeta.HeaderSize)}
+
+	var src io.Reader = lc // The decoder's reader.
+
+	d := json.NewDecoder(src)
+	d.UseNumber()
+
+	dOffset := headerOffset // The offset of the decoder's first byte.
+
+	for {
+		var raw json.RawMessage
+
+		err := d.Decode(&raw)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if _, ok := err.(*json.SyntaxError); !ok && err != io.ErrUnexpectedEOF {
+				return fmt.Errorf("error: json-stream decode, file: %s/%s,"+
+					" offset: %d, err: %v", p.dirBaseAnon, p.fnameAnon, dOffset+d.InputOffset(), err)
+			}
+
+			// Skip the rest of the malformed value's line, and resync
+			// with a new decoder on the next line.
+			startOffset := dOffset + d.InputOffset()
+
+			br := bufio.NewReader(io.MultiReader(d.Buffered(), src))
+
+			spaces, skipped, err := skipLine(br)
+
+			startOffset += spaces
+
+			p.explain = p.run.isExplained(startOffset, lc.lineAt(startOffset))
+			p.parseError(startOffset, lc.lineAt(startOffset),
+				[]string{strings.TrimRight(skipped, "\r\n")}, "bad json")
+			p.explain = false
+
+			if err == io.EOF {
+				return nil // The malformed value was the last.
+			}
+			if err != nil {
+				return err
+			}
+
+			src = br
+
+			d = json.NewDecoder(src)
+			d.UseNumber()
+
+			dOffset = startOffset + int64(len(skipped))
+
+			continue
+		}
+
+		// The raw value excludes any whitespace that preceded it.
+		startOffset := dOffset + d.InputOffset() - int64(len(raw))
+
+		p.processJSONStreamValue(startOffset, lc.lineAt(startOffset), raw)
+
+		if p.run.outputLimited() {
+			return nil
+		}
+	}
+}
+
+// skipLine reads the rest of a line, after any leading whitespace,
+// returning the count of whitespace bytes and the line.
+func skipLine(br *bufio.Reader) (int64, string, error) {
+	var spaces int64
+
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return spaces, "", err
+		}
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			br.UnreadByte()
+			break
+		}
+		spaces++
+	}
+
+	line, err := br.ReadString('\n')
+
+	return spaces, line, err
+}
+
+// processJSONStreamValue emits a JSON object of a stream as an entry,
+// whose lines are those of the object, as originally formatted.
+func (p *fileProcessor) processJSONStreamValue(startOffset, startLine int64,
+	raw json.RawMessage) {
+	lines := strings.Split(string(raw), "\n")
+
+	p.explain = p.run.isExplained(startOffset, startLine)
+	if p.explain {
+		defer func() { p.explain = false }()
+	}
+
+	var m map[string]interface{}
+
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	if d.Decode(&m) != nil {
+		// A value that's not an object, like a bare number.
+		p.parseError(startOffset, startLine, lines, "not an object")
+		return
+	}
+
+	ts := tsNone
+	for _, k := range jsonStreamTSKeys {
+		if v, exists := m[k]; exists {
+			ts = "" // A bad timestamp, unless another key parses.
+			if t, ok := jsonTS(v); ok {
+				ts = t
+				break
+			}
+		}
+	}
+
+	level := ""
+	if typ, ok := m["type"].(string); ok &&
+		strings.Contains(strings.ToLower(typ), "error") {
+		level = "ERRO"
+	}
+
+	if p.explain {
+		p.explainf("json-stream, ts: %s, bytes: %d", ts, len(raw))
+	}
+
+	if reason := badEntry(ts, lines); reason != "" {
+		p.parseError(startOffset, startLine, lines, reason)
+		return
+	}
+
+	p.processEntryParsed(startOffset, startLine, ts, "", level, lines, nil)
+}
+
+// jsonTS returns the timestamp of a JSON value, which is either a
+// number of seconds since the epoch or an RFC 3339 timestamp string.
+func jsonTS(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		secs, err := v.Float64()
+		if err != nil {
+			return "", false
+		}
+
+		whole, frac := math.Modf(secs)
+		return time.Unix(int64(whole), int64(frac*1e9)).UTC().Format(tsLayout), true
+
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return "", false
+		}
+		return t.Format(tsLayout), true // The wall time, like text logs.
+	}
+
+	return "", false
+}
+
+// A lineCounter is a reader that tracks the offsets of the newlines
+// that it reads, so that the line of a later offset can be found.
+type lineCounter struct {
+	r        io.Reader
+	offset   int64   // Offset of the next byte to be read.
+	lines    int64   // Count of the newlines before the newlines slice.
+	newlines []int64 // Offsets of the newlines not yet counted.
+}
+
+func (lc *lineCounter) Read(b []byte) (int, error) {
+	n, err := lc.r.Read(b)
+	for i := 0; i < n; i++ {
+		if b[i] == '\n' {
+			lc.newlines = append(lc.newlines, lc.offset+int64(i))
+		}
+	}
+	lc.offset += int64(n)
+	return n, err
+}
+
+// lineAt returns the 1-based line of an offset, where the offsets of
+// successive calls must not decrease.
+func (lc *lineCounter) lineAt(offset int64) int64 {
+	for len(lc.newlines) > 0 && lc.newlines[0] < offset {
+		lc.newlines = lc.newlines[1:]
+		lc.lines++
+	}
+	return lc.lines + 1
+}
@@ -0,0 +1,51 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestFindJSONTrailingText(t *testing.T) {
+	buf := []byte(`memstats {"Alloc":79226592, "Sys":{"a":1}} took 2ms {oops`)
+
+	start, end, v := findJSON(buf)
+	if start != 9 || string(buf[end:]) != " took 2ms {oops" {
+		t.Fatalf("start: %d, rest: %q", start, buf[end:])
+	}
+	if v == nil {
+		t.Fatalf("expected a decoded object")
+	}
+}
+
+func TestParseJSONTrailingText(t *testing.T) {
+	out := runFixture(t, "-parseJSON", "-emitParts", "FULL,VALS",
+		"-emitTypes", "INT,STRING", "testdata/json")
+
+	expectLines(t, out,
+		"2016-04-11T20:53:31.327 INFO json/ns_server.projector.log 220:5 VALS projector memstats Alloc = INT 79226592",
+		"2016-04-11T20:53:31.327 INFO json/ns_server.projector.log 220:5 VALS projector memstats Sys = INT 12",
+		"2016-04-11T20:53:31.327 INFO json/ns_server.projector.log 220:5 VALS projector took = INT 2")
+}
+
+func TestJSONStreamResyncs(t *testing.T) {
+	out := runFixture(t, "-onParseError", "emit", "-emitParts", "FULL",
+		"testdata/jsonstream")
+
+	expectLines(t, out,
+		`2016-04-14T23:10:06.262 INFO jsonstream/master_events.log 0:1 master_events {"type":"rebalanceStart","ts":1460675406.262}`,
+		`0000-00-00T00:00:00.000 NONE jsonstream/master_events.log 46:2 master_events {"type":"bad",,"ts":1460675407.262}`,
+		`2016-04-14T23:10:08.262 INFO jsonstream/master_events.log 82:3 master_events { "type": "vbucketMoveStart", "ts": 1460675408.262 }`,
+		`0000-00-00T00:00:00.000 NONE jsonstream/master_events.log 141:7 master_events {"type":"truncated","ts":14606`,
+		`2016-04-14T23:10:09.262 INFO jsonstream/master_events.log 172:8 master_events {"type":"rebalanceEnd","ts":1460675409.262}`,
+		`0000-00-00T00:00:00.000 NONE jsonstream/master_events.log 216:9 master_events {"type":"cut`)
+}
@@ -0,0 +1,60 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// latencyMS matches an entry against the LatencyStartRE and the
+// LatencyEndRE, where a start is remembered by its id until its end
+// is seen, returning the milliseconds between a start and its end,
+// or "" when the entry isn't the end of a known start. Starts that
+// are older than the LatencyTTL are forgotten, to bound memory.
+func (p *fileProcessor) latencyMS(ts string, buf []byte) string {
+	t, err := parseTS(ts)
+	if err != nil {
+		return ""
+	}
+
+	if p.latencyStarts == nil {
+		p.latencyStarts = map[string]time.Time{}
+	}
+
+	if t.Sub(p.latencySwept) > p.run.LatencyTTL {
+		for id, start := range p.latencyStarts {
+			if t.Sub(start) > p.run.LatencyTTL {
+				delete(p.latencyStarts, id)
+			}
+		}
+
+		p.latencySwept = t
+	}
+
+	s := string(buf)
+
+	if id := submatchNamedOrFirst(p.run.latencyEndRE, "id", s); id != "" {
+		start, exists := p.latencyStarts[id]
+		if exists {
+			delete(p.latencyStarts, id)
+
+			return strconv.FormatInt(int64(t.Sub(start)/time.Millisecond), 10)
+		}
+	}
+
+	if id := submatchNamedOrFirst(p.run.latencyStartRE, "id", s); id != "" {
+		p.latencyStarts[id] = t
+	}
+
+	return ""
+}
@@ -0,0 +1,206 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// From query, where the logfmt fields might be in any order, and
+// where a last _msg might be unquoted, running to the end of line...
+//
+//	_time=2016-04-05T13:23:05.378+01:00 _level=INFO _msg=Created New Bucket default
+//	_level=WARN _msg="request timed out" _time=2021-03-01T10:00:00.123-07:00 request_id=42
+//	_time=2021-03-01T10:00:00.123-07:00 _level=INFO keyspace default:travel-sample.inventory.airline
+
+// The well-known logfmt keys, in order of precedence.
+var (
+	logfmtTimeKeys   = []string{"_time", "time", "ts"}
+	logfmtLevelKeys  = []string{"_level", "level", "lvl"}
+	logfmtModuleKeys = []string{"_module", "module", "logger"}
+	logfmtMsgKeys    = []string{"_msg", "msg"}
+)
+
+var re_logfmt_ts = regexp.MustCompile(`^(\d\d\d\d-\d\d-\d\d)[T ](\d\d:\d\d:\d\d)(?:[.,](\d+))?`)
+
+// A logfmtField is a key=value field of a logfmt line, where a field
+// with an empty key holds the bare words that aren't a field.
+type logfmtField struct {
+	key, val string
+	quoted   bool
+}
+
+// parseLogfmt parses the key=value and key="quoted value" fields of a
+// line, in order, where the bare words that follow an unquoted msg
+// field are part of the msg, and other bare words become fields with
+// an empty key.
+func parseLogfmt(line string) (fields []logfmtField) {
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimLeft(line, " \t") {
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+
+		eq := strings.IndexByte(line[0:end], '=')
+		if eq <= 0 {
+			word := line[0:end]
+			line = line[end:]
+
+			if n := len(fields); n > 0 && !fields[n-1].quoted &&
+				(fields[n-1].key == "" || isLogfmtKey(fields[n-1].key, logfmtMsgKeys)) {
+				fields[n-1].val += " " + word
+			} else {
+				fields = append(fields, logfmtField{"", word, false})
+			}
+			continue
+		}
+
+		key := line[0:eq]
+		line = line[eq+1:]
+
+		if strings.HasPrefix(line, `"`) {
+			if n := quotedLen(line); n > 0 {
+				val, err := strconv.Unquote(line[0:n])
+				if err != nil {
+					val = line[1 : n-1]
+				}
+				fields = append(fields, logfmtField{key, val, true})
+				line = line[n:]
+				continue
+			}
+		}
+
+		end = strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+
+		fields = append(fields, logfmtField{key, line[0:end], false})
+		line = line[end:]
+	}
+
+	return fields
+}
+
+// quotedLen returns the length of the double-quoted string that
+// starts the line, including its quotes, or 0 when it's unclosed.
+func quotedLen(line string) int {
+	for i := 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func isLogfmtKey(key string, keys []string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// logfmtLookup returns the value of the first of the keys that's a
+// field, along with the field's index, or -1.
+func logfmtLookup(fields []logfmtField, keys []string) (string, int) {
+	for _, key := range keys {
+		for i, field := range fields {
+			if field.key == key {
+				return field.val, i
+			}
+		}
+	}
+	return "", -1
+}
+
+// logfmtTS returns the emitted form of the timestamp of a line's
+// logfmt fields, or "" when the line isn't a logfmt entry.
+func logfmtTS(fields []logfmtField) string {
+	val, i := logfmtLookup(fields, logfmtTimeKeys)
+	if i < 0 {
+		return ""
+	}
+
+	m := re_logfmt_ts.FindStringSubmatch(val)
+	if m == nil {
+		return ""
+	}
+
+	return tsFit(m[1] + "T" + m[2] + "." + m[3])
+}
+
+// isLogfmtEntry returns true when a line starts a logfmt entry, having
+// a well-known time field, regardless of the order of its fields.
+func isLogfmtEntry(line string) bool {
+	return strings.Contains(line, "=") && logfmtTS(parseLogfmt(line)) != ""
+}
+
+// processLogfmtEntry processes an entry whose first line has logfmt
+// fields, where the well-known keys are the ts, level, module and
+// message, and the other fields are VALS parts, or returns false when
+// the first line isn't a logfmt entry.
+func (p *fileProcessor) processLogfmtEntry(startOffset, startLine int64,
+	lines []string) bool {
+	fields := parseLogfmt(lines[0])
+
+	ts := logfmtTS(fields)
+	if ts == "" {
+		return false
+	}
+
+	if p.explain {
+		p.explainf("logfmt fields: %q", fields)
+	}
+
+	if reason := badEntry(ts, lines); reason != "" {
+		p.parseError(startOffset, startLine, lines, reason)
+		return true
+	}
+
+	level, _ := logfmtLookup(fields, logfmtLevelKeys)
+	module, _ := logfmtLookup(fields, logfmtModuleKeys)
+	msg, _ := logfmtLookup(fields, logfmtMsgKeys)
+
+	var vals []entryVal
+
+	for _, field := range fields {
+		switch {
+		case field.key == "":
+			msg = strings.TrimSpace(msg + " " + field.val)
+
+		case isLogfmtKey(field.key, logfmtTimeKeys),
+			isLogfmtKey(field.key, logfmtLevelKeys),
+			isLogfmtKey(field.key, logfmtModuleKeys),
+			isLogfmtKey(field.key, logfmtMsgKeys):
+			// Already handled.
+
+		default:
+			if _, err := strconv.ParseInt(field.val, 10, 64); err == nil {
+				vals = append(vals, entryVal{field.key, "INT", field.val})
+			} else {
+				vals = append(vals, entryVal{field.key, "STRING", field.val})
+			}
+		}
+	}
+
+	p.processEntryParsed(startOffset, startLine, ts, module, normalizeLevel(level),
+		append([]string{msg}, lines[1:]...), vals)
+
+	return true
+}
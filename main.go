@@ -12,22 +12,46 @@
 package main
 
 import (
+	"archive/tar"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"go/token"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
+	"net/url"
 	"os"
 	"path"
+	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 var ScannerBufferCapacity = 20 * 1024 * 1024
 
+// ReverseMaxFileSize is the largest file whose entries are all
+// buffered for the Reverse param, when there's no Tail param.
+var ReverseMaxFileSize = int64(100 * 1024 * 1024)
+
+// SinceRestartMaxBytes caps the bytes of the entries that are buffered
+// for the SinceLastRestart param, for a file that can't be scanned
+// twice, beyond which the buffered entries are emitted early.
+var SinceRestartMaxBytes = int64(100 * 1024 * 1024)
+
+// MaxQuotedEntryLines caps how many lines an entry with an unclosed
+// double-quote or brace may swallow, when lines that look like the
+// start of a new entry are treated as being inside the quoted string
+// or braces, so that a stray quote or brace can't merge the rest of a
+// file into one entry.
+var MaxQuotedEntryLines = 100
+
 func main() {
 	run, flagSet := parseArgsToRun(os.Args)
 
@@ -37,10 +61,20 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  -%s=%s\n", f.Name, f.Value)
 	})
 
+	if run.Compare != "" {
+		run.compareDicts(os.Stdout)
+		return
+	}
+
 	emittedFiles := map[string]io.Closer{} // Keyed by path.
 
-	if run.run["stdout"] || run.run["std"] {
-		run.addEmitter(run.EmitParts, run.EmitTypes, os.Stdout)
+	var otlp *otlpShipper
+
+	if run.EmitFormat == "otlp" {
+		otlp = newOTLPShipper(run.OTLPEndpoint, &run.emitBytes)
+		run.EntryCallback = otlp.entry
+	} else if run.run["stdout"] || run.run["std"] {
+		run.addEmitter(run.EmitParts, run.EmitTypes, run.EmitFormat, os.Stdout)
 	}
 
 	if run.run["tmp"] || run.run["web"] {
@@ -80,12 +114,38 @@ func main() {
 		}
 	}
 
+	if len(emittedFiles) > 0 {
+		go run.closeOnSignal(emittedFiles)
+	}
+
 	if run.run["webServer"] || run.run["web"] {
 		go run.webServer()
 	}
 
-	if len(run.emitters) > 0 {
-		run.processDirs()
+	if len(run.emitters) > 0 || otlp != nil {
+		if run.WatchDir {
+			run.watchDirs() // Never returns.
+		} else {
+			start := time.Now()
+
+			run.processDirs()
+
+			if otlp != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", otlp.done())
+			}
+
+			if run.BenchReport {
+				run.emitBenchReport(start)
+			}
+
+			if run.LevelHistogram > 0 {
+				run.emitLevelHistogram()
+			}
+
+			if run.tsRanges != nil {
+				run.emitTSRanges()
+			}
+		}
 	}
 
 	if len(emittedFiles) > 0 {
@@ -125,19 +185,394 @@ func main() {
 
 // Run is the main data struct that describes a processing run.
 type Run struct {
-	EmitDict  string // Path to optional JSON dictionary file to output.
-	EmitOrig  string // When non-"", original log entries will be emitted to stdout.
-	EmitParts string // Comma-separated list of parts of data to emit (VALS, MIDS, ENDS).
-	EmitTypes string // Comma-separated list of value types to emit (INT, STRING).
-
-	Dirs []string // Input directories to process.
+	// When true, the hosts, IP addresses, uuids and bucket names of the
+	// entries and of the emitted file names are replaced by consistent
+	// pseudonyms, from a hash keyed by the AnonymizeSalt, for sharing
+	// logs while preserving correlation; a "" AnonymizeSalt means a
+	// random key, so different runs don't cross-correlate.
+	Anonymize     bool
+	AnonymizeSalt string
+
+	// When true, every entry of a file whose first lines have a
+	// Couchbase version banner gets the cb_version and cb_build VALS
+	// parts, for comparing behavior across builds. The banners are
+	// otherwise only looked for when FILE records are emitted.
+	AttachVersion bool
+
+	// When true, the parse throughput and the allocation and GC stats
+	// of the run are printed to stderr at the end of the run.
+	BenchReport bool
+
+	// When true, a true or false that follows a name, like "enabled:
+	// true", is kept as its own BOOL value, rather than being merged
+	// into its neighboring tokens, as names are never true or false.
+	BoolVals bool
+
+	// When true (the default), the serial processing of a file reuses
+	// the lines slice of its entries, to reduce garbage, where the
+	// entries sent to the EntryWorkers always get their own slices.
+	BufferReuse bool
+
+	// Optional, comma-separated paths of two JSON dictionaries, like
+	// "a/emit.dict,b/emit.dict", as emitted by EmitDict from two runs,
+	// where the names added, removed or changed from the first to the
+	// second are printed, instead of processing any dirs.
+	Compare string
+
+	// When true, FULL entries get a confidence, from 0.0 to 1.0,
+	// scoring how cleanly the entry was parsed, so that the dubious
+	// parses can be flagged for review.
+	Confidence bool
+
+	// When true, entries get a correlation_id VALS part, which is a
+	// hash of the entry's node, module and normalized message, so the
+	// same entry is correlated across overlapping cbcollects.
+	CorrelationID bool
+
+	// When > 0, up to this many distinct value literals per name are
+	// kept as Samples in the EmitDict JSON dictionary.
+	DictSamples int
+
+	// Optional, comma-separated list of names, like "uuid,conn_id",
+	// whose VALS parts are neither emitted nor added to the dictionary.
+	DropNames string
+
+	EmitAlignWidth int    // Module column width for the "aligned" EmitFormat.
+	EmitCooccur    string // Path to optional name co-occurrence report, CSV when ".csv", else JSON.
+	EmitDict       string // Path to optional JSON dictionary file to output.
+	EmitFormat     string // Format of stdout, like "" (the default), "aligned", "json" or "otlp".
+	EmitOrig       string // When non-"", original log entries will be emitted to stdout.
+	EmitParts      string // Comma-separated list of parts of data to emit (VALS, MIDS, ENDS).
+	EmitTypes      string // Comma-separated list of value types to emit (INT, STRING).
+
+	Dirs []string // Input directories, or cbcollect_info .tar.gz bundles, to process.
+
+	// Optional callback that's invoked serially with every parsed
+	// entry as a structured Entry, once all of the entry's parts have
+	// been emitted, for programs that embed mortimint's parsing.
+	EntryCallback func(Entry)
+
+	// When > 1, the entries of a file are cleansed, tokenized and
+	// emitted by this many concurrent workers, while the file is being
+	// scanned, where the emitted order of the entries is preserved.
+	// Ignored when params that need the entries to be processed in
+	// order are used, like SeqRE.
+	EntryWorkers int
+
+	// Optional path to a JSON file that maps event types to regexps,
+	// like {"failover": "(?i)failed over"}, which add to or override
+	// the EventSignatures that label entries with an event_type.
+	EventSignatures string
+
+	// Optional "offset:line" or "offset" of entries, as emitted in the
+	// ol column, whose parsing is traced to stderr, for debugging.
+	Explain string
+
+	// Optional comma-separated names of OptionalExtractors to apply
+	// to the entries of every file, like "collections".
+	Extract string
+
+	// When true, the run exits with an error when no entries can be
+	// parsed from a file, such as when the file's format has drifted
+	// from its FileMeta, which is useful when validating metas.
+	FailOnNoMatch bool
+
+	// When true, the FileMeta of a file whose name is unknown is picked
+	// by sniffing the file's first lines, where the FileMeta whose
+	// EntryRE matches the most lines is used, and is logged.
+	FormatAuto bool
+
+	// Optional, like "pid", where the entries of each file are buffered
+	// in windows of GroupByWindow entries, and emitted grouped by the
+	// key, such as the erlang process of ns_server entries, so that a
+	// process's interleaved entries appear contiguously.
+	GroupBy       string
+	GroupByWindow int
+
+	// When true, the files emitted to the OutDir are gzip compressed,
+	// with a ".gz" suffix, like "full.log.gz".
+	GzipOut bool
+
+	// When true, entries that can't be parsed are emitted as FULL
+	// placeholders, with a tsNone timestamp, a NONE level and a
+	// parse_failed VALS part, so that every line is accounted for.
+	IncludeEmptyEntries bool
+
+	// When true, an entry whose first line starts with a time-only
+	// timestamp, like "13:23:05.388", gets the date of the previous
+	// timestamp of its file, where the date is incremented when the
+	// time goes backwards by more than an hour, like at midnight.
+	InferDate bool
+
+	// When true, an entry whose level can't be parsed gets the level
+	// that its file's name encodes, like the ERRO of the "error" of
+	// "ns_server.error.log", from the FilenameLevels, which are added
+	// to or overridden by the LevelFromFilenameMap, which also implies
+	// LevelFromFilename, like "error=ERROR,babysitter=INFO".
+	LevelFromFilename    bool
+	LevelFromFilenameMap string
+
+	// When > 0, like "1m", the counts of the levels of the entries,
+	// per bucket of this interval of their timestamps, are printed to
+	// stderr at the end of the run, as a compact time series.
+	LevelHistogram time.Duration
+
+	// Optional, comma-separated allowlist of levels, like "WARN,ERRO",
+	// where only the entries of those levels are emitted, regardless
+	// of the ordering of the levels, unlike the MinLevel.
+	Levels string
+
+	// When true, every line is processed as its own log entry,
+	// instead of merging multi-line log entries.
+	LineMode bool
+
+	// Optional bounds on the byte sizes of the files to process, where
+	// a MaxFileSizePercentile, like 90, also skips the files that are
+	// larger than that percentile of the sizes of all the files, so
+	// that a quick pass can skip the few giant files of a cbcollect.
+	MaxFileSize           int64
+	MaxFileSizePercentile float64
+	MinFileSize           int64
+
+	// When > 0, the dictionary stops adding new names once it has this
+	// many names, bounding its memory on high-cardinality logs.
+	MaxDictSize int
+
+	// When > 0, the processing of each file stops after this many of
+	// its entries, like to extract a window of entries after a Seek.
+	MaxEntries int
+
+	// When > 0, like 2147483648 for 2GB, the features that buffer
+	// entries are flushed early once the heap nears this many bytes,
+	// degrading rather than running out of memory: the GroupBy groups
+	// are flushed, and so might be split; a Reverse without a Tail
+	// emits the entries buffered so far, so a file is emitted as
+	// reversed runs of entries; a Tail keeps only its last entries;
+	// the TraceID's entries are emitted so far, so they're in
+	// timestamp order only within each flush; and the SinceLastRestart
+	// entries of a file that can't be scanned twice are emitted so far,
+	// so they might precede the last restart. Nothing spills to disk.
+	MaxMemory int64
+
+	// When > 0, emitting stops at the next entry after the emitters
+	// have written, or the "otlp" EmitFormat has marshaled, this many
+	// bytes.
+	MaxOutputBytes int64
+
+	// When > 0, VALS parts are emitted with paths of at most this many
+	// segments, where anything nested deeper is flattened into a JSON
+	// object that's the value of the first segment past the cut.
+	MaxPathDepth int
+
+	// When > 0, emitted values and FULL entry bodies that are longer
+	// than this many bytes are truncated, with an ellipsis appended,
+	// and the emitted line is marked with truncated=true.
+	MaxValueLen int
+
+	// The policy for an entry that can't be parsed, like "skip" (the
+	// default), "emit", which emits a placeholder like with
+	// IncludeEmptyEntries, or "abort", which exits with an error, where
+	// entries with a bad timestamp or invalid UTF-8 are also parse
+	// errors, whatever the file's format.
+	OnParseError string
+
+	// When true, only entries whose content matches an error
+	// signature are emitted, regardless of their level.
+	OnlyEntriesWithErrors bool
+
+	// Optional, comma-separated list of modules, like "ns_server,xdcr",
+	// where entries of other modules are skipped before they're
+	// cleansed and tokenized.
+	OnlyModules string
+
+	// Optional regexps of the start and of the end of an operation,
+	// which find the operation's id from their "id" named group or
+	// else their first group, where an end entry that follows its
+	// start entry within the LatencyTTL gets a latency_ms VALS part.
+	LatencyStartRE string
+	LatencyEndRE   string
+	LatencyTTL     time.Duration
+
+	// Optional, like "WARN", where entries of a lower level, like
+	// "INFO" or "DEBUG", are skipped.
+	MinLevel string
+
+	// Optional canonical case of the emitted modules, whether captured
+	// by an EntryRE, from a DefaultModule or from the file name, like
+	// "lower" or "upper", where "" (the default) keeps the original.
+	ModuleCase string
+
+	// Optional, comma-separated list of glob patterns, like
+	// "*/memcached.log,ns_server.*.log", matched against the
+	// "dirBase/fname" or the fname of files to process.
+	Members string
+
+	// The OTLP/HTTP logs endpoint that entries are sent to, for the
+	// "otlp" EmitFormat.
+	OTLPEndpoint string
+
+	// Regexp of the characters or patterns that disqualify a parsed
+	// name, such as `[<>/ ]`, which rejects names like "</foo bar>".
+	NameReject string
 
 	OutDir string // Output directory to use.
 
+	// When true, JSON objects embedded in entries are parsed with a
+	// JSON decoder rather than with the heuristic tokenizer.
+	ParseJSON bool
+
+	// Optional, comma-separated path prefix, like "supervisor" or
+	// "supervisor,child", where only the tokenized parts whose path
+	// starts with the prefix are emitted.
+	PathFilter string
+
+	// Separator, like the default "." or "/", where the name path of a
+	// VALS part is emitted joined by the separator, like "a.b", or else
+	// when "", in the form like "[a b]", which the files emitted to the
+	// OutDir always keep, as the web server's graphData parses it.
+	PathSeparator string
+
+	// When true, the objects of the "json" EmitFormat are indented
+	// across multiple lines, for reading in a terminal, where the
+	// output is then no longer newline-delimited JSON.
+	PrettyJSON bool
+
 	ProgressEvery int // When > 0 emit progress every this many entries.
 
+	// Optional, comma-separated, case-insensitive field names, like
+	// "request_id,traceId", whose value is emitted as a request_id VALS
+	// part, for following a request across the logs of services.
+	RequestIDFields string
+
+	// Regexp of the restart marker line, like memcached's "Restarting
+	// file logging", which is used by the SinceLastRestart mode.
+	RestartRE string
+
+	// When > 0, the entries of each file are emitted paced by the
+	// deltas of their timestamps, multiplied by this factor, like 0.1
+	// for a 10x faster replay, simulating their real-time arrival.
+	ReplayDelay float64
+
+	// When true, the entries of each file are emitted in reverse,
+	// newest-first order, which requires buffering the entries, so
+	// files larger than ReverseMaxFileSize, and gzip'ed files, whose
+	// uncompressed sizes are unknown, also need a Tail.
+	Reverse bool
+
+	// When > 0, an entry whose timestamp goes backwards by more than
+	// this duration, like where rotated log files were concatenated
+	// out of order, is marked with a rotation_boundary VALS part.
+	RotationThreshold time.Duration
+
+	// Optional path to a JSON rules file, where an entry that meets all
+	// the conditions of a Rule, on its module, level and content, gets
+	// the rule's tag as a tag VALS part, for encoding triage knowledge.
+	Rules string
+
 	Run string // Comma-separated list of the kind of run, like "stdout,web".
 
+	// When true, only the entries at or after the last line matching
+	// the RestartRE are emitted from each file, which is found by a
+	// first pass over a local file, or else by buffering the entries
+	// since the latest restart, up to SinceRestartMaxBytes, as of a
+	// bundle, of a URL or of a gzip'ed file.
+	SinceLastRestart bool
+
+	// When true, an entry with an endpoint whose port is a well-known
+	// Couchbase port, like the 8093 of "127.0.0.1:8093", gets a service
+	// VALS part, like "query", from the ServicePorts, which are added
+	// to or overridden by the ServiceFromPortMap, which also implies
+	// ServiceFromPort, like "8091=ns_server,9000=proxy".
+	ServiceFromPort    bool
+	ServiceFromPortMap string
+
+	// Optional, comma-separated list of go tokens, like "^,&", that
+	// the tokenizer drops, in addition to "<<" and ">>", where a token
+	// prefixed by '!', like "!<<", is no longer dropped.
+	SkipTokens string
+
+	// When true, entries get a status VALS part, like "ok", "error"
+	// or "unknown", from the first of the StatusSignatures that the
+	// entry matches, for counting failures uniformly across logs.
+	Status bool
+
+	// Optional path to a JSON file that maps statuses to regexps,
+	// like {"error": "(?i)\\bfailed\\b"}, which add to or replace
+	// the StatusSignatures, and which implies the Status param.
+	StatusSignatures string
+
+	// When true, the last line of a file's skipped header is checked
+	// against the FileMeta's HeaderRE, where a mismatch, such as from a
+	// header that's longer or shorter than the HeaderSize, is reported.
+	StrictHeader bool
+
+	// When true, ANSI escape sequences, like terminal color codes from
+	// captured console output, are removed from each line.
+	StripANSI bool
+
+	// Optional "offset:line" or "offset" of an entry, as emitted in the
+	// ol column, where the processing of each file starts at the byte
+	// offset, re-syncing to the next entry start after a mid-entry
+	// offset; where lines are counted from the offset, unless the
+	// entry's line is also given. Usually used with Members.
+	Seek string
+
+	// Optional regexp that finds a monotonic sequence number in the
+	// first line of an entry, from its "seq" named group or first group.
+	// When a module's sequence skips, a seq_gap VALS part is emitted.
+	SeqRE string
+
+	SeqBase int // The number base of the sequence numbers, like 10 or 16.
+
+	// When > 0, only the last Tail entries of each file are emitted.
+	Tail int
+
+	// Optional directory where a copy of the raw, and any gunzip'ed,
+	// content of every processed file is written, as a side effect of
+	// parsing, like "teeRaw/cbcollect_n1/memcached.log".
+	TeeRaw string
+
+	// Optional regexp that finds a thread or goroutine id in the first
+	// line of an entry, from its "thread" named group or first group.
+	// An EntryRE's "thread" named group, if any, takes precedence.
+	ThreadRE string
+
+	// Optional timestamp, like "2016-04-19T23:10:31.209", that's used
+	// as the origin for a t_rel VALS part emitted with every entry.
+	TimeOrigin string
+
+	// When true (the default), timestamps whose fractional seconds are
+	// sloppy, like "31,209" with a comma separator or "31.2" with fewer
+	// than 3 digits, are parsed and normalized, like to "31.209" and
+	// "31.200", or else their entries are parse errors.
+	TimestampTolerance bool
+
+	// When true, every tokenized entry's token decisions are traced
+	// to stderr, like the levelDelta of each token, the tokens that are
+	// skipped or merged into the previous token, and the emitted names.
+	TokenizerDebug bool
+
+	// Optional request id, where only the entries with that request_id,
+	// from any file, are emitted, ordered by their timestamps, once all
+	// the files are processed. The RequestIDFields default to common
+	// field names, like "request_id" and "traceId".
+	TraceID string
+
+	// Optional, like "file" or "module", where the earliest and latest
+	// timestamps of the emitted entries of every file, or of every
+	// module of every file, are printed to stderr at the end of the
+	// run, for knowing whether the logs cover an incident's window.
+	TSRanges string
+
+	// When true, the dirs are polled every WatchInterval for files
+	// that newly appear, which are then processed, until the process
+	// is killed; and, when Follow is true, every file is followed like
+	// with "tail -f", where an entry is processed when the next entry
+	// starts.
+	WatchDir      bool
+	WatchInterval time.Duration
+	Follow        bool
+
 	WebAddr   string // Host:Port to use for web server.
 	WebStatic string // Path to web static resources dir.
 
@@ -145,6 +580,53 @@ type Run struct {
 
 	run map[string]bool // Result of parsing the Run param.
 
+	latencyStartRE *regexp.Regexp // Result of parsing the LatencyStartRE param.
+	latencyEndRE   *regexp.Regexp // Result of parsing the LatencyEndRE param.
+
+	requestIDNames map[string]bool // Result of parsing the RequestIDFields param, lowercased.
+	requestIDRE    *regexp.Regexp  // Result of parsing the RequestIDFields param.
+
+	nameReject *regexp.Regexp // Result of parsing the NameReject param.
+
+	pathFilter []string // Result of parsing the PathFilter param.
+
+	onlyModules map[string]bool // Result of parsing the OnlyModules param.
+
+	levels map[string]bool // Result of parsing the Levels param, normalized.
+
+	onParseError string // Result of the OnParseError and IncludeEmptyEntries params.
+
+	skipTokens map[token.Token]bool // Result of parsing the SkipTokens param.
+
+	anonymizer *anonymizer // Non-nil when the Anonymize param is true.
+
+	dropNames map[string]bool // Result of parsing the DropNames param.
+
+	eventSignatures []EventSignature // Result of the EventSignatures param.
+
+	statusSignatures []StatusSignature // Result of the StatusSignatures param.
+
+	filenameLevels map[string]string // Result of the LevelFromFilename params.
+
+	servicePorts map[string]string // Result of the ServiceFromPort params.
+
+	rules []Rule // Result of the Rules param.
+
+	extractors []Extractor // Result of parsing the Extract param.
+
+	restartRE  *regexp.Regexp // Result of parsing the RestartRE param.
+	seqRE      *regexp.Regexp // Result of parsing the SeqRE param.
+	threadRE   *regexp.Regexp // Result of parsing the ThreadRE param.
+	timeOrigin time.Time      // Result of parsing the TimeOrigin param.
+
+	seekOffset, seekLine int64 // Result of parsing the Seek param.
+
+	fileSizeCutoff int64 // Result of the MaxFileSizePercentile param.
+
+	// sniffed is keyed by "dirBase/fname", holding the FileMetas that
+	// were picked by the FormatAuto param for unknown file names.
+	sniffed map[string]FileMeta
+
 	totFiles       int // Total number of files to process.
 	maxFNameOutLen int
 	spaces         string // len(spaces) == maxFNameOutLen, used for padding.
@@ -152,20 +634,54 @@ type Run struct {
 	// fileSizes is keyed by dirBase, then by file name.
 	fileSizes map[string]map[string]int64
 
+	// gzipped is keyed by "dirBase/fname", of the gunzip'ed files,
+	// whose fileProgress isn't updated until they're done, as their
+	// fileSizes are the compressed sizes.
+	gzipped map[string]bool
+
 	// fileProcessors is keyed by dirBase, then by file name.
 	fileProcessors map[string]map[string]*fileProcessor
 
 	emitters []*Emitter
 
+	origOut io.Writer // Where the EmitOrig entries are written, counted.
+
+	memM         sync.Mutex // Protects the memory fields that follow.
+	memCheckedAt time.Time  // When the heap was last checked, for MaxMemory.
+	memPressured bool       // Result of the last check of the heap.
+
+	tracedM sync.Mutex // Serializes the emitTraced flushes.
+
 	m sync.Mutex // Protects the fields that follow.
 
 	emitDone     bool
 	emitProgress int64                       // Total number of emitXxxx() calls.
+	emitBytes    int64                       // Total bytes written by emitters.
+	emitLimited  bool                        // True once MaxOutputBytes is reached.
 	fileProgress map[string]map[string]int64 // Byte offsets reached.
 
 	minTS, maxTS string
 
-	dict Dict
+	entriesParsed int64 // Total number of parsed entries of all files.
+
+	// levelCounts is keyed by LevelHistogram bucket timestamp, then by
+	// level, holding the entry counts of all files.
+	levelCounts map[string]map[string]int64
+
+	// tsRanges is keyed by "dirBase/fname", or by "dirBase/fname module"
+	// when the TSRanges param is "module".
+	tsRanges map[string]*tsRange
+
+	traced []*tracedEntry // Entries with the TraceID, awaiting emitTraced.
+
+	dict     Dict
+	dictFull bool // True once the dict is truncated by the MaxDictSize.
+
+	entries map[string]*Entry // Pending EntryCallback entries, keyed by "dirBase/fname".
+
+	// Pending FILE records, keyed by "dirBase/fname", which are emitted
+	// along with the first emit of their file.
+	fileRecords map[string]func()
 }
 
 // ------------------------------------------------------------
@@ -173,21 +689,79 @@ type Run struct {
 func parseArgsToRun(args []string) (*Run, *flag.FlagSet) {
 	run := &Run{
 		fileSizes:      map[string]map[string]int64{},
+		gzipped:        map[string]bool{},
 		fileProcessors: map[string]map[string]*fileProcessor{},
 		fileProgress:   map[string]map[string]int64{},
 		dict:           Dict{},
+		entries:        map[string]*Entry{},
+		fileRecords:    map[string]func(){},
+		levelCounts:    map[string]map[string]int64{},
+		sniffed:        map[string]FileMeta{},
 	}
 
+	run.origOut = &countWriter{w: os.Stdout, n: &run.emitBytes}
+
 	flagSet := flag.NewFlagSet(args[0], flag.ExitOnError)
 
+	flagSet.BoolVar(&run.Anonymize, "anonymize", false,
+		"optional, when true, hosts, IP addresses, uuids and bucket names are\n"+
+			"        replaced by consistent pseudonyms, for sharing logs.")
+	flagSet.StringVar(&run.AnonymizeSalt, "anonymizeSalt", "",
+		"optional, salt of the anonymize pseudonyms, where runs with the same salt\n"+
+			"        have the same pseudonyms; the default is a random salt per run.")
+	flagSet.BoolVar(&run.AttachVersion, "attachVersion", false,
+		"optional, when true, every entry gets the cb_version and cb_build\n"+
+			"        of its file's Couchbase version banner, when found.")
+	flagSet.BoolVar(&run.BenchReport, "benchReport", false,
+		"optional, when true, parse throughput, allocation and GC stats\n"+
+			"        are printed to stderr at the end of the run.")
+	flagSet.BoolVar(&run.BoolVals, "boolVals", false,
+		"optional, when true, a true or false that follows a name, like\n"+
+			"        enabled: true, is emitted as a BOOL name=value pair.")
+	flagSet.BoolVar(&run.BufferReuse, "bufferReuse", true,
+		"optional, when true, the lines of entries that are processed serially\n"+
+			"        reuse a buffer; entries sent to entryWorkers are always copied.")
+	flagSet.StringVar(&run.Compare, "compare", "",
+		"optional, comma-separated paths of two emitDict JSON dictionaries,\n"+
+			"        like a.dict,b.dict, whose added, removed and changed names are printed.")
+	flagSet.BoolVar(&run.Confidence, "confidence", false,
+		"optional, when true, FULL entries get a confidence from 0.0 to 1.0, scoring\n"+
+			"        the entry's regexp match, bracket balance and tokenizer errors.")
+	flagSet.BoolVar(&run.CorrelationID, "correlationID", false,
+		"optional, when true, entries get a correlation_id from a hash of\n"+
+			"        their node, module and message, which is stable across cbcollects.")
+	flagSet.IntVar(&run.DictSamples, "dictSamples", 0,
+		"optional, when > 0, the max number of distinct value samples kept\n"+
+			"        per name in the emitDict JSON dictionary.")
+	flagSet.StringVar(&run.DropNames, "dropNames", "",
+		"optional, comma-separated list of names whose VALS are not emitted.")
+	flagSet.IntVar(&run.EmitAlignWidth, "emitAlignWidth", 24,
+		"optional, when > 0, the width that the module column is padded to\n"+
+			"        in the aligned emitFormat, where the ts and level columns have\n"+
+			"        fixed widths; longer modules are not truncated.")
+	flagSet.StringVar(&run.EmitCooccur, "emitCooccur", "",
+		"optional, path to a report of the names that co-occur in entries,\n"+
+			"        as a CSV matrix when the path ends with .csv, or else as JSON.")
 	flagSet.StringVar(&run.EmitDict, "emitDict", "",
 		"optional, path to JSON dictionary output file.")
+	flagSet.StringVar(&run.EmitFormat, "emitFormat", "",
+		"optional, format of the output emitted to stdout; supported values:\n"+
+			"          \"\"      - the default, space separated format;\n"+
+			"          aligned - pads the ts, level and module columns into\n"+
+			"                    consistent widths, for reading in a terminal;\n"+
+			"          json    - one JSON object per line, with the keys ts, module,\n"+
+			"                    level, dir, fname, ol, offset, line, kind, path,\n"+
+			"                    name, tok and val;\n"+
+			"          otlp    - instead of stdout, entries are sent as OpenTelemetry\n"+
+			"                    log records to the otlpEndpoint.\n"+
+			"       ")
 	flagSet.StringVar(&run.EmitOrig, "emitOrig", "",
 		"when not the empty string (\"\"), source log lines are emitted to stdout;\n"+
 			"        when \"single\", source log entries are joined into a single line;\n"+
 			"        this is useful when debugging mortimint.")
 	flagSet.StringVar(&run.EmitParts, "emitParts", "FULL",
 		"optional, comma-separated list of parts to emit; supported values:\n"+
+			"          FILE - emit a record of a file's metadata before its entries;\n"+
 			"          FULL - emit full log entry, with only light parsing;\n"+
 			"          VALS - emit name=value pairs;\n"+
 			"          MIDS - uncommon; emit strings in between the name=value pairs;\n"+
@@ -196,10 +770,47 @@ func parseArgsToRun(args []string) (*Run, *flag.FlagSet) {
 	flagSet.StringVar(&run.EmitTypes, "emitTypes", "INT",
 		"optional, comma-separated list of VALS value types to emit; supported values:\n"+
 			"          INT    - emit integer name=value pairs;\n"+
-			"          STRING - emit string name=value pairs.\n"+
+			"          STRING - emit string name=value pairs;\n"+
+			"          BOOL   - emit true or false name=value pairs, see boolVals.\n"+
 			"       ")
+	flagSet.BoolVar(&run.ParseJSON, "parseJSON", false,
+		"optional, when true, JSON objects embedded in log entries are parsed\n"+
+			"        as JSON, and any text before or after them is tokenized as usual.")
+	flagSet.StringVar(&run.PathFilter, "pathFilter", "",
+		"optional, comma-separated path prefix, like \"supervisor,child\", where only\n"+
+			"        the parts whose name path starts with the prefix are emitted.")
+	flagSet.StringVar(&run.PathSeparator, "pathSeparator", ".",
+		"optional, separator like . or / or :, where the name path of an emitted\n"+
+			"        VALS part is joined by the separator, like a.b, or else when empty,\n"+
+			"        like [a b], the form which the outDir files always keep,\n"+
+			"        as the web graphs depend on it.")
+	flagSet.BoolVar(&run.PrettyJSON, "pretty", false,
+		"optional, when true, the json emitFormat's objects are indented across\n"+
+			"        lines, so the output is no longer newline-delimited JSON.")
 	flagSet.IntVar(&run.ProgressEvery, "progressEvery", 0,
 		"optional, when > 0, emit a progress to stderr after modulo this many emits.")
+	flagSet.StringVar(&run.RequestIDFields, "requestIDFields", "",
+		"optional, comma-separated, case-insensitive field names, like\n"+
+			"        \"request_id,traceId\", whose value is emitted as a request_id\n"+
+			"        VALS part, like for request_id=abc or \"traceId\": \"abc\".")
+	flagSet.StringVar(&run.RestartRE, "restartRE", `Restarting file logging`,
+		"optional, regexp of a process restart marker line, used by sinceLastRestart.")
+	flagSet.BoolVar(&run.Reverse, "reverse", false,
+		"optional, when true, emit the entries of each file in reverse,\n"+
+			"        newest-first order; large or gzip'ed files also need a tail.")
+	flagSet.Float64Var(&run.ReplayDelay, "replayDelay", 0,
+		"optional, factor like 0.1, where the entries of each file are emitted\n"+
+			"        with sleeps of their timestamp deltas times the factor,\n"+
+			"        simulating a real-time playback of the logs.")
+	flagSet.DurationVar(&run.RotationThreshold, "rotationThreshold", 0,
+		"optional, like 1m, where an entry whose timestamp goes backwards by more\n"+
+			"        than this duration is marked with a rotation_boundary VALS part,\n"+
+			"        whose value is the backwards jump, like \"-01:00:00.000\".")
+	flagSet.StringVar(&run.Rules, "rules", "",
+		"optional, path to JSON rules file, like [{\"tag\": \"network\",\n"+
+			"        \"level\": \"ERROR\", \"contains\": \"timeout\"}], where an entry that\n"+
+			"        meets a rule's module, level, minLevel, contains and re conditions\n"+
+			"        gets the rule's tag as a tag VALS part.")
 	flagSet.StringVar(&run.Run, "run", "std",
 		"optional, comma-separated list of the kind of run; supported values:\n"+
 			"          emit      - emits full/vals.log and emit.dict to outDir;\n"+
@@ -210,8 +821,197 @@ func parseArgsToRun(args []string) (*Run, *flag.FlagSet) {
 			"          web       - convenience alias for \"tmp,emit,webServer\";\n"+
 			"          webServer - run a web server with previously emit'ed logs and dict.\n"+
 			"       ")
+	flagSet.IntVar(&run.EntryWorkers, "entryWorkers", 0,
+		"optional, when > 1, the number of concurrent workers that tokenize\n"+
+			"        and emit the entries of each file while the file is scanned,\n"+
+			"        where the order of the emitted entries is preserved.")
+	flagSet.StringVar(&run.EventSignatures, "eventSignatures", "",
+		"optional, path to JSON file of event signatures, like\n"+
+			"        {\"failover\": \"(?i)failed over\"}, where an entry that matches\n"+
+			"        an event signature's regexp is labeled with an event_type VALS part.")
+	flagSet.StringVar(&run.Explain, "explain", "",
+		"optional, the \"offset:line\" or \"offset\" of log entries, as emitted\n"+
+			"        in the output, whose parsing is traced step by step to stderr;\n"+
+			"        this is useful when debugging mortimint.")
+	flagSet.StringVar(&run.Extract, "extract", "",
+		"optional, comma-separated names of optional extractors to apply to\n"+
+			"        every file; supported values: collections.")
+	flagSet.BoolVar(&run.FailOnNoMatch, "failOnNoMatch", false,
+		"optional, when true, exit with an error when no entries can be parsed\n"+
+			"        from a file, which catches log format drift.")
+	flagSet.BoolVar(&run.Follow, "follow", false,
+		"optional, when true with watchDir, files are followed as they grow,\n"+
+			"        like with tail -f.")
+	flagSet.BoolVar(&run.FormatAuto, "formatAuto", false,
+		"optional, when true, files with unknown names are processed with\n"+
+			"        the FileMeta whose entry regexp best matches their first lines.")
+	flagSet.StringVar(&run.GroupBy, "groupBy", "",
+		"optional, like pid, where the entries of each file are emitted grouped\n"+
+			"        by the erlang process of their header, within windows of\n"+
+			"        groupByWindow entries; supported values: pid.")
+	flagSet.IntVar(&run.GroupByWindow, "groupByWindow", 1000,
+		"optional, the max number of entries buffered for groupBy.")
+	flagSet.BoolVar(&run.GzipOut, "gzipOut", false,
+		"optional, when true, files emitted to the outDir are gzip compressed.")
+	flagSet.BoolVar(&run.IncludeEmptyEntries, "includeEmptyEntries", false,
+		"optional, when true, entries that can't be parsed are also emitted,\n"+
+			"        with a level of NONE and a parse_failed VALS part.")
+	flagSet.BoolVar(&run.InferDate, "inferDate", false,
+		"optional, when true, entries with time-only timestamps like 13:23:05\n"+
+			"        get the date of their file's previous timestamp, rolling over at midnight.")
+	flagSet.StringVar(&run.LatencyEndRE, "latencyEndRE", "",
+		"optional, regexp of the end of an operation, used with latencyStartRE.")
+	flagSet.StringVar(&run.LatencyStartRE, "latencyStartRE", "",
+		"optional, regexp of the start of an operation, whose \"id\" named group\n"+
+			"        or else first group is the operation's id, like `start op=(\\d+)`;\n"+
+			"        when an entry matches the latencyEndRE with the same id, a\n"+
+			"        latency_ms VALS part is emitted with the end entry.")
+	flagSet.DurationVar(&run.LatencyTTL, "latencyTTL", 10*time.Minute,
+		"optional, duration after which an operation's start that has no end\n"+
+			"        is forgotten, which bounds the memory of the latency tracking.")
+	flagSet.BoolVar(&run.LevelFromFilename, "levelFromFilename", false,
+		"optional, when true, entries whose level can't be parsed get the level\n"+
+			"        of their file's name, like ERROR for ns_server.error.log.")
+	flagSet.StringVar(&run.LevelFromFilenameMap, "levelFromFilenameMap", "",
+		"optional, comma-separated fnameBase=level pairs, like error=ERROR,\n"+
+			"        which add to the levelFromFilename levels, and which imply it.")
+	flagSet.DurationVar(&run.LevelHistogram, "levelHistogram", 0,
+		"optional, interval like 1m, where the counts of each level per\n"+
+			"        interval are printed to stderr at the end of the run.")
+	flagSet.StringVar(&run.Levels, "levels", "",
+		"optional, comma-separated levels like WARN,ERROR, where only the\n"+
+			"        entries of those levels are emitted, regardless of minLevel ordering.")
+	flagSet.BoolVar(&run.LineMode, "lineMode", false,
+		"optional, when true, every line is processed as its own log entry,\n"+
+			"        instead of heuristically merging lines into multi-line entries.")
+	flagSet.IntVar(&run.MaxDictSize, "maxDictSize", 0,
+		"optional, when > 0, the max number of names in the dictionary,\n"+
+			"        after which new names are dropped, with a warning.")
+	flagSet.IntVar(&run.MaxEntries, "maxEntries", 0,
+		"optional, when > 0, the processing of each file stops after this\n"+
+			"        many entries.")
+	flagSet.Int64Var(&run.MaxFileSize, "maxFileSize", 0,
+		"optional, when > 0, files larger than this many bytes are skipped.")
+	flagSet.Float64Var(&run.MaxFileSizePercentile, "maxFileSizePercentile", 0,
+		"optional, when > 0, like 90, files larger than this percentile of\n"+
+			"        the sizes of all the files are skipped.")
+	flagSet.Int64Var(&run.MaxMemory, "maxMemory", 0,
+		"optional, when > 0, once the heap nears this many bytes, the entries\n"+
+			"        buffered by groupBy, reverse, tail and traceID are flushed early,\n"+
+			"        so their grouping or ordering is only kept within each flush.")
+	flagSet.Int64Var(&run.MaxOutputBytes, "maxOutputBytes", 0,
+		"optional, when > 0, stop emitting at the next log entry after\n"+
+			"        this many bytes of output have been emitted.")
+	flagSet.IntVar(&run.MaxPathDepth, "maxPathDepth", 0,
+		"optional, when > 0, the max number of path segments of emitted VALS,\n"+
+			"        where deeper nested values are emitted as a JSON object.")
+	flagSet.IntVar(&run.MaxValueLen, "maxValueLen", 0,
+		"optional, when > 0, emitted values and entry bodies longer than this\n"+
+			"        many bytes are truncated and marked with truncated=true.")
+	flagSet.StringVar(&run.Members, "members", "",
+		"optional, comma-separated list of glob patterns, like\n"+
+			"        \"*/memcached.log,ns_server.*.log\", where only the files whose\n"+
+			"        dir/name or name matches a pattern are processed.")
+	flagSet.Int64Var(&run.MinFileSize, "minFileSize", 0,
+		"optional, files smaller than this many bytes are skipped.")
+	flagSet.StringVar(&run.MinLevel, "minLevel", "",
+		"optional, level like WARN, where entries of lower levels like INFO\n"+
+			"        or DEBUG are skipped; supported levels, from lowest to highest:\n"+
+			"        DEBUG, INFO, NOTICE, WARN, ERROR, CRIT, ALERT, EMERG.")
+	flagSet.StringVar(&run.ModuleCase, "moduleCase", "",
+		"optional, case of the emitted modules, like lower or upper;\n"+
+			"        the default of \"\" keeps the original case.")
+	flagSet.StringVar(&run.NameReject, "nameReject", `[<>/ ]`,
+		"optional, regexp that rejects a parsed name when it matches,\n"+
+			"        so the name's value is not emitted as a VALS part.")
+	flagSet.StringVar(&run.OTLPEndpoint, "otlpEndpoint", "http://localhost:4318/v1/logs",
+		"optional, the OTLP/HTTP logs endpoint for the otlp emitFormat.")
+	flagSet.StringVar(&run.OnParseError, "onParseError", "",
+		"optional, policy for log entries that can't be parsed, or that have\n"+
+			"        a bad timestamp or invalid UTF-8, of skip, emit or abort,\n"+
+			"        where emit is like includeEmptyEntries.")
+	flagSet.BoolVar(&run.OnlyEntriesWithErrors, "onlyEntriesWithErrors", false,
+		"optional, when true, only emit entries whose content looks like an error,\n"+
+			"        such as {error,...}, badmatch or a non-2xx HTTP status,\n"+
+			"        even when the entry's level is not an error level.")
+	flagSet.StringVar(&run.OnlyModules, "onlyModules", "",
+		"optional, comma-separated list of modules, like \"ns_server,xdcr\",\n"+
+			"        where only the entries of those modules are processed.")
 	flagSet.StringVar(&run.OutDir, "outDir", "",
 		"optional, output directory to use.")
+	flagSet.StringVar(&run.Seek, "seek", "",
+		"optional, the \"offset:line\" or \"offset\" of a log entry, as emitted\n"+
+			"        in the output, where the processing of each file starts,\n"+
+			"        re-syncing to the next entry when the offset is mid-entry.")
+	flagSet.IntVar(&run.SeqBase, "seqBase", 10,
+		"optional, number base of the sequence numbers found by seqRE,\n"+
+			"        like 16 for hex sequence numbers.")
+	flagSet.StringVar(&run.SeqRE, "seqRE", "",
+		"optional, regexp that finds a sequence number in the first line\n"+
+			"        of an entry, from its \"seq\" named group or else its first group;\n"+
+			"        when a module's sequence numbers skip, which means messages\n"+
+			"        were dropped, a seq_gap VALS part is emitted; for example,\n"+
+			"        `##([0-9a-f]+)` with a seqBase of 16.")
+	flagSet.BoolVar(&run.ServiceFromPort, "serviceFromPort", false,
+		"optional, when true, entries with an endpoint on a known Couchbase\n"+
+			"        port, like 127.0.0.1:8093, get a service VALS part, like query.")
+	flagSet.StringVar(&run.ServiceFromPortMap, "serviceFromPortMap", "",
+		"optional, comma-separated port=service pairs, like 9000=proxy,\n"+
+			"        which add to the serviceFromPort services, and which imply it.")
+	flagSet.StringVar(&run.SkipTokens, "skipTokens", "",
+		"optional, comma-separated go tokens, like ^,& that the tokenizer drops,\n"+
+			"        in addition to << and >>, where !<< stops dropping <<.")
+	flagSet.BoolVar(&run.SinceLastRestart, "sinceLastRestart", false,
+		"optional, when true, only emit the entries of each file starting from\n"+
+			"        the last line that matches the restartRE, such as for\n"+
+			"        post-crash analysis.")
+	flagSet.BoolVar(&run.Status, "status", false,
+		"optional, when true, entries get a status VALS part of \"ok\",\n"+
+			"        \"error\" or \"unknown\", from the built-in status signatures.")
+	flagSet.StringVar(&run.StatusSignatures, "statusSignatures", "",
+		"optional, path to JSON file of status signatures, like\n"+
+			"        {\"error\": \"(?i)\\\\bfailed\\\\b\"}, which replace the built-in\n"+
+			"        signatures of their statuses, and which implies -status.")
+	flagSet.BoolVar(&run.StrictHeader, "strictHeader", false,
+		"optional, when true, a file whose skipped header doesn't end with\n"+
+			"        the expected header line is reported, to catch format drift.")
+	flagSet.BoolVar(&run.StripANSI, "stripANSI", false,
+		"optional, when true, ANSI escape sequences like terminal color codes\n"+
+			"        are removed from each line before it's processed.")
+	flagSet.IntVar(&run.Tail, "tail", 0,
+		"optional, when > 0, only emit the last this many entries of each file.")
+	flagSet.StringVar(&run.TeeRaw, "teeRaw", "",
+		"optional, directory where a raw, decompressed copy of every processed\n"+
+			"        file is written while parsing.")
+	flagSet.StringVar(&run.ThreadRE, "threadRE", "",
+		"optional, regexp that finds a thread or goroutine id in the first line\n"+
+			"        of an entry, from its \"thread\" named group or else its first group,\n"+
+			"        which is emitted as a thread VALS part; for example,\n"+
+			"        `goroutine (\\d+)`.")
+	flagSet.StringVar(&run.TimeOrigin, "timeOrigin", "",
+		"optional, timestamp like 2016-04-19T23:10:31.209, which is the origin\n"+
+			"        of the emitted t_rel VALS part (like \"+00:01:23.456\"),\n"+
+			"        which is the time of each entry relative to the timeOrigin.")
+	flagSet.BoolVar(&run.TimestampTolerance, "timestampTolerance", true,
+		"optional, when true, timestamps with a comma separator or fewer than 3\n"+
+			"        digits of fractional seconds, like 31,209 or 31.2, are parsed and\n"+
+			"        normalized; when false, their entries are parse errors.")
+	flagSet.BoolVar(&run.TokenizerDebug, "tokenizerDebug", false,
+		"optional, when true, the tokenizer's decisions of every log entry,\n"+
+			"        like levelDelta, skipped and merged tokens, are traced to stderr.")
+	flagSet.StringVar(&run.TraceID, "traceID", "",
+		"optional, request id, where only the entries of all files whose\n"+
+			"        request_id is the traceID are emitted, in timestamp order;\n"+
+			"        requestIDFields defaults to "+defaultRequestIDFields+".")
+	flagSet.StringVar(&run.TSRanges, "tsRanges", "",
+		"optional, file or module, where the earliest and latest timestamps of\n"+
+			"        every file, or of every module of every file, are printed to stderr\n"+
+			"        at the end of the run.")
+	flagSet.BoolVar(&run.WatchDir, "watchDir", false,
+		"optional, when true, the dirs are watched by polling, where files that\n"+
+			"        appear are also processed, until mortimint is killed.")
+	flagSet.DurationVar(&run.WatchInterval, "watchInterval", 2*time.Second,
+		"optional, duration between polls of watchDir and follow.")
 	flagSet.StringVar(&run.WebAddr, "webAddr", ":8911",
 		"optional, addr:port to use for web server.\n"+
 			"       ")
@@ -226,7 +1026,261 @@ func parseArgsToRun(args []string) (*Run, *flag.FlagSet) {
 
 	run.Dirs = flagSet.Args()
 
+	if run.EmitFormat == "otlp" && run.WatchDir {
+		log.Fatalf("error: the otlp emitFormat can't be used with watchDir")
+	}
+
+	if run.TraceID != "" && run.WatchDir {
+		log.Fatalf("error: traceID can't be used with watchDir")
+	}
+
+	if run.Anonymize {
+		anonymizer, err := newAnonymizer(run.AnonymizeSalt)
+		if err != nil {
+			log.Fatalf("error: could not create anonymizer: %v", err)
+		}
+		run.anonymizer = anonymizer
+	}
+
+	if run.PrettyJSON && run.EmitFormat != "json" {
+		log.Fatalf("error: pretty needs the json emitFormat")
+	}
+
+	skipTokens, err := parseSkipTokens(run.SkipTokens)
+	if err != nil {
+		log.Fatalf("error: could not parse skipTokens: %v", err)
+	}
+	run.skipTokens = skipTokens
+
+	if run.TokenizerDebug {
+		fmt.Fprintf(os.Stderr, "skipTokens: %s\n", skipTokensString(run.skipTokens))
+	}
+
+	if run.TSRanges != "" {
+		if run.TSRanges != "file" && run.TSRanges != "module" {
+			log.Fatalf("error: tsRanges must be file or module, got: %q", run.TSRanges)
+		}
+		run.tsRanges = map[string]*tsRange{}
+	}
+
+	if run.MinLevel != "" && levelRank(normalizeLevel(run.MinLevel)) < 0 {
+		log.Fatalf("error: unknown minLevel: %q", run.MinLevel)
+	}
+
+	if run.GroupBy != "" && run.GroupBy != "pid" {
+		log.Fatalf("error: unknown groupBy: %q", run.GroupBy)
+	}
+	if run.GroupByWindow < 1 {
+		run.GroupByWindow = 1
+	}
+
+	switch run.OnParseError {
+	case "", "skip", "emit", "abort":
+	default:
+		log.Fatalf("error: unknown onParseError: %q", run.OnParseError)
+	}
+
+	run.onParseError = run.OnParseError
+	if run.onParseError == "" {
+		run.onParseError = "skip"
+		if run.IncludeEmptyEntries {
+			run.onParseError = "emit"
+		}
+	}
+
+	if run.ModuleCase != "" && run.ModuleCase != "lower" && run.ModuleCase != "upper" {
+		log.Fatalf("error: unknown moduleCase: %q", run.ModuleCase)
+	}
+
+	eventSignatures, err := loadEventSignatures(run.EventSignatures)
+	if err != nil {
+		log.Fatalf("error: could not load eventSignatures: %v", err)
+	}
+	run.eventSignatures = eventSignatures
+
+	rules, err := loadRules(run.Rules)
+	if err != nil {
+		log.Fatalf("error: could not load rules: %v", err)
+	}
+	run.rules = rules
+
+	if run.Status || run.StatusSignatures != "" {
+		statusSignatures, err := loadStatusSignatures(run.StatusSignatures)
+		if err != nil {
+			log.Fatalf("error: could not load statusSignatures: %v", err)
+		}
+		run.statusSignatures = statusSignatures
+	}
+
+	if run.LevelFromFilename || run.LevelFromFilenameMap != "" {
+		filenameLevels, err := loadFilenameLevels(run.LevelFromFilenameMap)
+		if err != nil {
+			log.Fatalf("error: could not parse levelFromFilenameMap: %v", err)
+		}
+		run.filenameLevels = filenameLevels
+	}
+
+	if run.ServiceFromPort || run.ServiceFromPortMap != "" {
+		servicePorts, err := loadServicePorts(run.ServiceFromPortMap)
+		if err != nil {
+			log.Fatalf("error: could not parse serviceFromPortMap: %v", err)
+		}
+		run.servicePorts = servicePorts
+	}
+
+	for _, name := range strings.Split(run.Extract, ",") {
+		if name == "" {
+			continue
+		}
+
+		extractor, exists := OptionalExtractors[name]
+		if !exists {
+			log.Fatalf("error: unknown extract: %q", name)
+		}
+		run.extractors = append(run.extractors, extractor)
+	}
+
+	if run.LatencyStartRE != "" || run.LatencyEndRE != "" {
+		if run.LatencyStartRE == "" || run.LatencyEndRE == "" {
+			log.Fatalf("error: latencyStartRE and latencyEndRE must be used together")
+		}
+
+		latencyStartRE, err := regexp.Compile(run.LatencyStartRE)
+		if err != nil {
+			log.Fatalf("error: could not parse latencyStartRE: %v", err)
+		}
+		run.latencyStartRE = latencyStartRE
+
+		latencyEndRE, err := regexp.Compile(run.LatencyEndRE)
+		if err != nil {
+			log.Fatalf("error: could not parse latencyEndRE: %v", err)
+		}
+		run.latencyEndRE = latencyEndRE
+	}
+
+	if run.TraceID != "" && run.RequestIDFields == "" {
+		run.RequestIDFields = defaultRequestIDFields
+	}
+
+	if run.RequestIDFields != "" {
+		requestIDNames, requestIDRE, err := parseRequestIDFields(run.RequestIDFields)
+		if err != nil {
+			log.Fatalf("error: could not parse requestIDFields: %v", err)
+		}
+		run.requestIDNames, run.requestIDRE = requestIDNames, requestIDRE
+	}
+
+	if run.NameReject != "" {
+		nameReject, err := regexp.Compile(run.NameReject)
+		if err != nil {
+			log.Fatalf("error: could not parse nameReject: %v", err)
+		}
+		run.nameReject = nameReject
+	}
+
+	if run.OnlyModules != "" {
+		run.onlyModules = csvToMap(run.OnlyModules, map[string]bool{})
+	}
+
+	if run.Levels != "" {
+		run.levels = map[string]bool{}
+		for _, level := range strings.Split(run.Levels, ",") {
+			run.levels[normalizeLevel(strings.TrimSpace(level))] = true
+		}
+	}
+
+	if run.DropNames != "" {
+		run.dropNames = csvToMap(run.DropNames, map[string]bool{})
+	}
+
+	if run.PathFilter != "" {
+		run.pathFilter = strings.Split(run.PathFilter, ",")
+	}
+
+	if run.SinceLastRestart {
+		restartRE, err := regexp.Compile(run.RestartRE)
+		if err != nil {
+			log.Fatalf("error: could not parse restartRE: %v", err)
+		}
+		run.restartRE = restartRE
+	}
+
+	if run.SeqRE != "" {
+		seqRE, err := regexp.Compile(run.SeqRE)
+		if err != nil {
+			log.Fatalf("error: could not parse seqRE: %v", err)
+		}
+		run.seqRE = seqRE
+	}
+
+	if run.ThreadRE != "" {
+		threadRE, err := regexp.Compile(run.ThreadRE)
+		if err != nil {
+			log.Fatalf("error: could not parse threadRE: %v", err)
+		}
+		run.threadRE = threadRE
+	}
+
+	if run.TimeOrigin != "" {
+		timeOrigin, err := parseTS(run.TimeOrigin)
+		if err != nil {
+			log.Fatalf("error: could not parse timeOrigin: %v", err)
+		}
+		run.timeOrigin = timeOrigin
+	}
+
+	if run.Seek != "" {
+		offset, line := run.Seek, "1"
+		if colon := strings.IndexByte(offset, ':'); colon >= 0 {
+			offset, line = offset[0:colon], offset[colon+1:]
+		}
+
+		seekOffset, err := strconv.ParseInt(offset, 10, 64)
+		if err != nil || seekOffset < 0 {
+			log.Fatalf("error: could not parse seek offset: %q", run.Seek)
+		}
+
+		seekLine, err := strconv.ParseInt(line, 10, 64)
+		if err != nil || seekLine < 1 {
+			log.Fatalf("error: could not parse seek line: %q", run.Seek)
+		}
+
+		run.seekOffset, run.seekLine = seekOffset, seekLine
+	}
+
+	var selected []selectedFile
+
 	for _, dir := range run.Dirs {
+		if isURL(dir) {
+			dirBase, fname := urlDirBaseFName(dir)
+
+			_, exists := run.selectFile(dirBase, fname)
+			if !exists {
+				fmt.Fprintf(os.Stderr, "skipping url: %s\n", dir)
+				continue
+			}
+
+			run.addFileSize(dirBase, fname, 0) // Size is learned during GET.
+
+			continue
+		}
+
+		if isBundle(dir) {
+			// The bundle is streamed, here only for its tar headers.
+			err := walkBundle(dir, func(dirBase, fname string,
+				hdr *tar.Header, tr *tar.Reader) error {
+				if _, exists := run.selectFile(dirBase, fname); exists {
+					selected = append(selected, selectedFile{dirBase, hdr.FileInfo()})
+				}
+				return nil
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			continue
+		}
+
 		fileInfos, err := ioutil.ReadDir(dir)
 		if err != nil {
 			log.Fatal(err)
@@ -235,23 +1289,30 @@ func parseArgsToRun(args []string) (*Run, *flag.FlagSet) {
 		dirBase := path.Base(dir)
 
 		for _, fileInfo := range fileInfos {
-			fmeta, exists := FileMetas[fileInfo.Name()]
-			if exists && !fmeta.Skip {
-				run.totFiles += 1
-
-				x := len(dirBase) + len(fileInfo.Name()) + 1
-				if run.maxFNameOutLen < x {
-					run.maxFNameOutLen = x
-				}
+			if run.FormatAuto && !fileInfo.IsDir() {
+				run.sniffFileMeta(dir, dirBase, fileInfo.Name())
+			}
 
-				if run.fileSizes[dirBase] == nil {
-					run.fileSizes[dirBase] = map[string]int64{}
-				}
-				run.fileSizes[dirBase][fileInfo.Name()] = fileInfo.Size()
+			_, exists := run.selectFile(dirBase, fileInfo.Name())
+			if exists {
+				selected = append(selected, selectedFile{dirBase, fileInfo})
 			}
 		}
 	}
 
+	if run.MaxFileSizePercentile > 0 {
+		run.fileSizeCutoff = fileSizePercentile(selected, run.MaxFileSizePercentile)
+	}
+
+	for _, sf := range selected {
+		if run.selectFileSize(sf.fileInfo.Size()) {
+			run.addFileSize(sf.dirBase, sf.fileInfo.Name(), sf.fileInfo.Size())
+		} else {
+			fmt.Fprintf(os.Stderr, "skipping file by size: %s/%s, size: %d\n",
+				run.anonymize(sf.dirBase), run.anonymize(sf.fileInfo.Name()), sf.fileInfo.Size())
+		}
+	}
+
 	run.spaces = strings.Repeat(" ", run.maxFNameOutLen+1)
 
 	run.run = csvToMap(run.Run, map[string]bool{})
@@ -259,11 +1320,63 @@ func parseArgsToRun(args []string) (*Run, *flag.FlagSet) {
 	return run, flagSet
 }
 
+// selectedFile is a file found by the pre-scan of the Dirs.
+type selectedFile struct {
+	dirBase  string
+	fileInfo os.FileInfo
+}
+
+// fileSizePercentile returns the size of the file at the given
+// percentile, like 90, of the sizes of the files.
+func fileSizePercentile(files []selectedFile, percentile float64) int64 {
+	if len(files) <= 0 {
+		return 0
+	}
+
+	sizes := make([]int64, 0, len(files))
+	for _, sf := range files {
+		sizes = append(sizes, sf.fileInfo.Size())
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+
+	i := int(math.Ceil(percentile/100*float64(len(sizes)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sizes) {
+		i = len(sizes) - 1
+	}
+
+	return sizes[i]
+}
+
+// selectFileSize returns true when a file of the given size is within
+// the MinFileSize, MaxFileSize and MaxFileSizePercentile bounds.
+func (run *Run) selectFileSize(size int64) bool {
+	return size >= run.MinFileSize &&
+		(run.MaxFileSize <= 0 || size <= run.MaxFileSize) &&
+		(run.fileSizeCutoff <= 0 || size <= run.fileSizeCutoff)
+}
+
+func (run *Run) addFileSize(dirBase, fname string, size int64) {
+	run.totFiles += 1
+
+	x := len(dirBase) + len(fname) + 1
+	if run.maxFNameOutLen < x {
+		run.maxFNameOutLen = x
+	}
+
+	if run.fileSizes[dirBase] == nil {
+		run.fileSizes[dirBase] = map[string]int64{}
+	}
+	run.fileSizes[dirBase][fname] = size
+}
+
 // ------------------------------------------------------------
 
 func (run *Run) processDirs() bool {
 	workCh := make(chan *fileProcessor, run.totFiles)
-	doneCh := make(chan *fileProcessor)
+	doneCh := make(chan *fileProcessor, run.totFiles) // Buffered for processBundle.
 
 	workers := run.Workers
 	if workers <= 0 {
@@ -273,16 +1386,25 @@ func (run *Run) processDirs() bool {
 	for i := 0; i < workers; i++ {
 		go func() {
 			for fp := range workCh {
-				err := fp.process()
-				if err != nil {
-					log.Fatal(err)
-				}
-				doneCh <- fp
+				run.processFile(fp, doneCh)
 			}
 		}()
 	}
 
 	for _, dir := range run.Dirs {
+		if isURL(dir) {
+			run.processURL(dir, workCh)
+			continue
+		}
+
+		if isBundle(dir) {
+			err := run.processBundle(dir, doneCh)
+			if err != nil {
+				log.Fatal(err)
+			}
+			continue
+		}
+
 		err := run.processDir(dir, workCh)
 		if err != nil {
 			log.Fatal(err)
@@ -294,13 +1416,21 @@ func (run *Run) processDirs() bool {
 	for i := 0; i < run.totFiles; i++ {
 		fp := <-doneCh
 		run.m.Lock()
-		fp.dict.AddTo(run.dict)
+		run.addDictLocked(fp.dict, fp.dictFull)
+		run.entriesParsed += fp.entriesParsed
+		addLevelCounts(run.levelCounts, fp.levelCounts)
 		run.fileProgress[fp.dirBase][fp.fname] = run.fileSizes[fp.dirBase][fp.fname]
 		run.m.Unlock()
 	}
 
+	if run.TraceID != "" {
+		run.emitTraced()
+	}
+
 	run.processEmitDict()
 
+	run.processEmitCooccur()
+
 	run.m.Lock()
 	run.emitDone = true
 	if run.ProgressEvery > 0 {
@@ -311,6 +1441,28 @@ func (run *Run) processDirs() bool {
 	return true
 }
 
+// processFile processes a file, then sends its fileProcessor to the
+// doneCh.
+func (run *Run) processFile(fp *fileProcessor, doneCh chan *fileProcessor) {
+	err := fp.process()
+	if err != nil {
+		log.Fatal(err)
+	}
+	run.entryFlush(fp.dirBase, fp.fname)
+
+	run.m.Lock()
+	run.fileRecordLocked(fp.dirBase, fp.fname) // When nothing was emitted.
+	run.m.Unlock()
+
+	// The matched entries count even when they were filtered out, like
+	// by the OnlyModules, Tail or SinceLastRestart params.
+	if run.FailOnNoMatch && fp.entriesParsed <= 0 && fp.entriesMatched <= 0 {
+		log.Fatalf("error: no entries were parsed from file: %s/%s",
+			fp.dirBaseAnon, fp.fnameAnon)
+	}
+	doneCh <- fp
+}
+
 func (run *Run) processDir(dir string, workCh chan *fileProcessor) error {
 	fileInfos, err := ioutil.ReadDir(dir)
 	if err != nil {
@@ -323,11 +1475,9 @@ func (run *Run) processDir(dir string, workCh chan *fileProcessor) error {
 
 	for _, fileInfo := range fileInfos {
 		fname := fileInfo.Name()
-		fnameBaseParts := strings.Split(strings.Replace(fname, ".log", "", -1), ".")
-		fnameBase := fnameBaseParts[len(fnameBaseParts)-1]
 
-		fmeta, exists := FileMetas[fname]
-		if !exists || fmeta.Skip {
+		fmeta, exists := run.selectFile(dirBase, fname)
+		if !exists || !run.selectFileSize(fileInfo.Size()) {
 			continue
 		}
 
@@ -335,16 +1485,7 @@ func (run *Run) processDir(dir string, workCh chan *fileProcessor) error {
 		run.fileProgress[dirBase] = map[string]int64{}
 		run.m.Unlock()
 
-		run.fileProcessors[dirBase][fname] = &fileProcessor{
-			run:       run,
-			dir:       dir,
-			dirBase:   dirBase,
-			fname:     fname,
-			fnameBase: fnameBase,
-			fnameOut:  (dirBase + "/" + fname + run.spaces)[0:run.maxFNameOutLen],
-			fmeta:     fmeta,
-			dict:      Dict{},
-		}
+		run.fileProcessors[dirBase][fname] = run.newFileProcessor(dir, dirBase, fname, fmeta)
 
 		workCh <- run.fileProcessors[dirBase][fname]
 	}
@@ -352,8 +1493,165 @@ func (run *Run) processDir(dir string, workCh chan *fileProcessor) error {
 	return nil
 }
 
+// processURL queues a fileProcessor for an http or https URL of a
+// single log file, like "http://host/cbcollect_info_ns_1@a/memcached.log",
+// where the URL's last two path elements are used as the dirBase and
+// file name.
+func (run *Run) processURL(u string, workCh chan *fileProcessor) {
+	dirBase, fname := urlDirBaseFName(u)
+
+	fmeta, exists := run.selectFile(dirBase, fname)
+	if !exists {
+		return
+	}
+
+	run.m.Lock()
+	if run.fileProgress[dirBase] == nil {
+		run.fileProgress[dirBase] = map[string]int64{}
+	}
+	run.m.Unlock()
+
+	if run.fileProcessors[dirBase] == nil {
+		run.fileProcessors[dirBase] = map[string]*fileProcessor{}
+	}
+
+	fp := run.newFileProcessor(urlDir(u), dirBase, fname, fmeta)
+	fp.url = u
+
+	run.fileProcessors[dirBase][fname] = fp
+
+	workCh <- fp
+}
+
+// selectFile returns the FileMeta of a file that should be processed,
+// or false when the file is unknown, skipped or filtered out.
+func (run *Run) selectFile(dirBase, fname string) (FileMeta, bool) {
+	fmeta, exists := lookupFileMeta(fname)
+	if !exists {
+		fmeta, exists = run.sniffed[dirBase+"/"+fname]
+	}
+	if !exists || fmeta.Skip {
+		return fmeta, false
+	}
+
+	if run.Members != "" {
+		for _, member := range strings.Split(run.Members, ",") {
+			matched, err := path.Match(member, dirBase+"/"+fname)
+			if !matched && err == nil {
+				matched, err = path.Match(member, fname)
+			}
+			if err != nil {
+				log.Fatalf("error: members pattern: %q, err: %v", member, err)
+			}
+			if matched {
+				return fmeta, true
+			}
+		}
+
+		return fmeta, false
+	}
+
+	return fmeta, true
+}
+
+func (run *Run) newFileProcessor(dir, dirBase, fname string,
+	fmeta FileMeta) *fileProcessor {
+	fnameBaseParts := strings.Split(
+		strings.Replace(fileMetaName(fname), ".log", "", -1), ".")
+	fnameBase := fnameBaseParts[len(fnameBaseParts)-1]
+
+	// The dirBase, like "cbcollect_info_ns_1@10.0.0.3_20160414-231044",
+	// and the fname are pseudonymized once, for every emitted record, as
+	// they stay the keys of the file's progress and pending records.
+	dirBaseAnon, fnameAnon := run.anonymize(dirBase), run.anonymize(fname)
+
+	fnameOut := (dirBase + "/" + fname + run.spaces)[0:run.maxFNameOutLen]
+	if run.anonymizer != nil {
+		// The pseudonyms might be longer than the originals.
+		fnameOut = dirBaseAnon + "/" + fnameAnon
+		if len(fnameOut) < run.maxFNameOutLen {
+			fnameOut += run.spaces[0 : run.maxFNameOutLen-len(fnameOut)]
+		}
+	}
+
+	return &fileProcessor{
+		run:         run,
+		dir:         dir,
+		dirBase:     dirBase,
+		dirBaseAnon: dirBaseAnon,
+		fname:       fname,
+		fnameAnon:   fnameAnon,
+		fnameBase:   fnameBase,
+		fnameOut:    fnameOut,
+		fmeta:       fmeta,
+		dict:        Dict{},
+	}
+}
+
+// ------------------------------------------------------------
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// urlDirBaseFName returns the last two path elements of a URL.
+func urlDirBaseFName(u string) (string, string) {
+	up, err := url.Parse(u)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dirBase := path.Base(path.Dir(up.Path))
+	if dirBase == "/" || dirBase == "." {
+		dirBase = up.Host
+	}
+
+	return dirBase, path.Base(up.Path)
+}
+
+// urlDir returns a URL without its last path element, query and
+// fragment, like "http://host/a" for "http://host/a/b.log?x=y".
+func urlDir(u string) string {
+	up, err := url.Parse(u)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	up.Path = path.Dir(up.Path)
+	up.RawPath, up.RawQuery, up.Fragment = "", "", ""
+
+	return up.String()
+}
+
+// fileMetaName returns the FileMetas key for a file name, which
+// might have a ".gz" suffix and a rotation suffix, like ".1".
+func fileMetaName(fname string) string {
+	return re_rotated.ReplaceAllString(strings.TrimSuffix(fname, ".gz"), "")
+}
+
+// re_rotated matches the suffix of a rotated file, like the ".1" of
+// "ns_server.debug.log.1".
+var re_rotated = regexp.MustCompile(`\.\d+$`)
+
 // ------------------------------------------------------------
 
+// addDictLocked merges a file's dict into the run's dict, warning
+// once when the run's dict is truncated by the MaxDictSize, where the
+// run's dict is also truncated when the file's dict was, as when
+// srcFull, which the file already warned about, and where the caller
+// must hold run.m.
+func (run *Run) addDictLocked(src Dict, srcFull bool) {
+	if !src.AddTo(run.dict, run.DictSamples, run.MaxDictSize) && !run.dictFull {
+		run.dictFull = true
+
+		fmt.Fprintf(os.Stderr, "maxDictSize reached, dictionary truncated\n")
+	}
+
+	if srcFull {
+		run.dictFull = true
+	}
+}
+
 func (run *Run) processEmitDict() {
 	if run.EmitDict != "" {
 		fmt.Fprintf(os.Stderr, "emitting JSON dictionary: %s\n", run.EmitDict)
@@ -365,50 +1663,234 @@ func (run *Run) processEmitDict() {
 		defer f.Close()
 
 		err = json.NewEncoder(f).Encode(struct {
-			MinTS string
-			MaxTS string
-			Dict  Dict
-		}{run.minTS, run.maxTS, run.dict})
+			MinTS     string
+			MaxTS     string
+			Dict      Dict
+			Truncated bool `json:"Truncated,omitempty"`
+		}{run.minTS, run.maxTS, run.dict, run.dictFull})
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 }
 
+// emitBenchReport prints the parse throughput and the allocation and
+// GC stats of the run to stderr, to help catch performance regressions
+// in the tokenizer and cleansers.
+func (run *Run) emitBenchReport(start time.Time) {
+	elapsed := time.Since(start)
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	run.m.Lock()
+	entries := run.entriesParsed
+	run.m.Unlock()
+
+	fmt.Fprintf(os.Stderr, "\nbench report:\n")
+	fmt.Fprintf(os.Stderr, "  elapsed: %v\n", elapsed)
+	fmt.Fprintf(os.Stderr, "  entries: %d\n", entries)
+	if elapsed > 0 {
+		fmt.Fprintf(os.Stderr, "  entries/sec: %.0f\n", float64(entries)/elapsed.Seconds())
+	}
+	fmt.Fprintf(os.Stderr, "  allocs: %d, bytes: %d\n", ms.Mallocs, ms.TotalAlloc)
+	if entries > 0 {
+		fmt.Fprintf(os.Stderr, "  allocs/entry: %d, bytes/entry: %d\n",
+			ms.Mallocs/uint64(entries), ms.TotalAlloc/uint64(entries))
+	}
+	fmt.Fprintf(os.Stderr, "  gc: %d, gc pause: %v\n",
+		ms.NumGC, time.Duration(ms.PauseTotalNs))
+}
+
+// emitLevelHistogram prints the level counts per LevelHistogram
+// bucket, oldest bucket first, with the levels ordered by severity,
+// like "2016-04-14T16:10:00.000 DEBUG=3 INFO=10 WARN=1".
+func (run *Run) emitLevelHistogram() {
+	run.m.Lock()
+	defer run.m.Unlock()
+
+	buckets := make([]string, 0, len(run.levelCounts))
+	for bucket := range run.levelCounts {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+
+	fmt.Fprintf(os.Stderr, "\nlevel histogram, interval: %v\n", run.LevelHistogram)
+
+	for _, bucket := range buckets {
+		counts := run.levelCounts[bucket]
+
+		levels := make([]string, 0, len(counts))
+		for level := range counts {
+			levels = append(levels, level)
+		}
+		sort.Slice(levels, func(i, j int) bool {
+			ri, rj := levelRank(levels[i]), levelRank(levels[j])
+			if ri != rj {
+				return ri < rj
+			}
+			return levels[i] < levels[j]
+		})
+
+		line := "  " + bucket
+		for _, level := range levels {
+			line += fmt.Sprintf(" %s=%d", level, counts[level])
+		}
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
+
+// A tsRange tracks the earliest and latest timestamps of the entries
+// of a file or of a module.
+type tsRange struct {
+	first, last string
+	entries     int64
+}
+
+// add returns the tsRange extended by the timestamp of an entry,
+// where a nil tsRange is allocated.
+func (r *tsRange) add(ts string) *tsRange {
+	if r == nil {
+		return &tsRange{first: ts, last: ts, entries: 1}
+	}
+
+	if r.first > ts {
+		r.first = ts
+	}
+	if r.last < ts {
+		r.last = ts
+	}
+	r.entries++
+
+	return r
+}
+
+// emitTSRanges prints the earliest and latest timestamps of every file,
+// or of every module of every file, to stderr, sorted by key.
+func (run *Run) emitTSRanges() {
+	run.m.Lock()
+	defer run.m.Unlock()
+
+	keys := make([]string, 0, len(run.tsRanges))
+	for key := range run.tsRanges {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(os.Stderr, "\ntimestamp ranges, by %s:\n", run.TSRanges)
+
+	for _, key := range keys {
+		r := run.tsRanges[key]
+		fmt.Fprintf(os.Stderr, "  %s %s %s entries=%d\n", r.first, r.last, key, r.entries)
+	}
+}
+
 // ------------------------------------------------------------
 
-func (run *Run) emitEntryFull(ts, module, level, dirBase,
-	fname, fnameBase, fnameOut, ol string,
-	startOffset, startLine int64, lines []string) {
+func (run *Run) emitEntryFull(ts, module, level, dirBase, dirBaseAnon,
+	fname, fnameAnon, fnameBase, fnameOut, ol string,
+	startOffset, startLine int64, lines []string, confidence string) {
 	var linesJoined string
+	var truncated bool
 
 	run.m.Lock()
 
+	if run.outputLimitedLocked() {
+		run.m.Unlock()
+		return
+	}
+
+	run.fileRecordLocked(dirBase, fname)
+
+	if run.EntryCallback != nil {
+		run.entryFullLocked(ts, module, level, dirBase, dirBaseAnon, fname, fnameAnon,
+			startOffset, startLine, lines)
+		run.entries[dirBase+"/"+fname].Confidence = confidence
+	}
+
 	for _, emitter := range run.emitters {
 		if emitter.emitParts["FULL"] {
 			if linesJoined == "" {
 				linesJoined = strings.Replace(strings.Join(lines, " "), "\n", " ", -1)
+				linesJoined, truncated = truncateVal(linesJoined, run.MaxValueLen)
 			}
 
-			emitter.emitEntryFull(ts, module, level, fnameOut, ol, linesJoined)
+			emitter.emitEntryFull(ts, module, level, dirBaseAnon, fnameAnon, fnameOut, ol,
+				startOffset, startLine, linesJoined, truncated, confidence)
 		}
 	}
 
 	run.emitCommonLocked(ts, dirBase, fname, startOffset)
 
+	if run.tsRanges != nil && ts != tsNone {
+		key := dirBaseAnon + "/" + fnameAnon
+		if run.TSRanges == "module" {
+			key += " " + module
+		}
+		run.tsRanges[key] = run.tsRanges[key].add(ts)
+	}
+
 	run.m.Unlock()
 }
 
-func (run *Run) emitEntryPart(ts, module, level, dirBase,
-	fname, fnameBase, fnameOut, ol string,
+// emitFileRecord holds the FILE record of a file until the file's
+// first emit, which might be a while with a Tail or under the Workers,
+// so that the FILE record immediately precedes its file's entries.
+func (run *Run) emitFileRecord(ts, module, dirBase, dirBaseAnon,
+	fname, fnameAnon, fnameOut, ol, fields string) {
+	run.m.Lock()
+
+	run.fileRecords[dirBase+"/"+fname] = func() {
+		for _, emitter := range run.emitters {
+			emitter.emitFileRecord(ts, module, dirBaseAnon, fnameAnon, fnameOut, ol, fields)
+		}
+	}
+
+	run.m.Unlock()
+}
+
+// fileRecordLocked emits the pending FILE record of a file, if any,
+// where the caller must hold run.m.
+func (run *Run) fileRecordLocked(dirBase, fname string) {
+	if len(run.fileRecords) <= 0 {
+		return
+	}
+
+	f := run.fileRecords[dirBase+"/"+fname]
+	if f != nil {
+		delete(run.fileRecords, dirBase+"/"+fname)
+
+		if !run.emitLimited {
+			f()
+		}
+	}
+}
+
+func (run *Run) emitEntryPart(ts, module, level, dirBase, dirBaseAnon,
+	fname, fnameAnon, fnameBase, fnameOut, ol string,
 	startOffset, startLine int64, partKind string,
 	namePath []string, name, valType, val string, valQuoted bool) {
 	if len(val) > 0 {
 		run.m.Lock()
 
+		if run.emitLimited {
+			run.m.Unlock()
+			return
+		}
+
+		run.fileRecordLocked(dirBase, fname)
+
+		val, truncated := truncateVal(val, run.MaxValueLen)
+
+		if run.EntryCallback != nil {
+			run.entryPartLocked(ts, module, level, dirBase, dirBaseAnon, fname, fnameAnon,
+				startOffset, startLine, partKind, namePath, name, valType, val)
+		}
+
 		for _, emitter := range run.emitters {
-			emitter.emitEntryPart(ts, module, level,
-				fnameOut, ol, partKind, namePath, name, valType, val, valQuoted)
+			emitter.emitEntryPart(ts, module, level, dirBaseAnon, fnameAnon, fnameOut, ol,
+				startOffset, startLine, partKind,
+				namePath, name, valType, val, valQuoted, truncated)
 		}
 
 		run.emitCommonLocked(ts, dirBase, fname, startOffset)
@@ -417,20 +1899,96 @@ func (run *Run) emitEntryPart(ts, module, level, dirBase,
 	}
 }
 
-func emitCommonPrep(module, fnameBase string, startOffset, startLine int64) (
+// truncateVal returns the val truncated to maxLen bytes on a rune
+// boundary, with an ellipsis appended, and true if it was truncated.
+func truncateVal(val string, maxLen int) (string, bool) {
+	if maxLen <= 0 || len(val) <= maxLen {
+		return val, false
+	}
+
+	n := maxLen
+	for n > 0 && !utf8.RuneStart(val[n]) {
+		n--
+	}
+
+	return val[0:n] + "...", true
+}
+
+// cbVersioned returns true when the Couchbase version banners are
+// looked for, which is only when they're used, by the AttachVersion
+// param or by the FILE records, as the search isn't cheap.
+func (run *Run) cbVersioned() bool {
+	if run.AttachVersion {
+		return true
+	}
+	for _, emitter := range run.emitters {
+		if emitter.emitParts["FILE"] {
+			return true
+		}
+	}
+	return false
+}
+
+// needsOrderedEntries returns true when a param tracks state across
+// the entries of a file, so the entries must be processed in order.
+func (run *Run) needsOrderedEntries() bool {
+	return run.seqRE != nil || run.RotationThreshold > 0 || run.latencyStartRE != nil ||
+		run.ReplayDelay > 0 || run.InferDate
+}
+
+// outputLimitedLocked returns true once the emitters have written the
+// MaxOutputBytes, which stops the emitting, where the caller must hold
+// run.m.
+func (run *Run) outputLimitedLocked() bool {
+	if run.MaxOutputBytes > 0 && run.emitBytes >= run.MaxOutputBytes && !run.emitLimited {
+		run.emitLimited = true
+
+		fmt.Fprintf(os.Stderr, "maxOutputBytes reached, %d bytes emitted,"+
+			" stopping emits\n", run.emitBytes)
+	}
+
+	return run.emitLimited
+}
+
+// outputLimited returns true once emitting has stopped due to the
+// MaxOutputBytes limit.
+func (run *Run) outputLimited() bool {
+	run.m.Lock()
+	emitLimited := run.emitLimited
+	run.m.Unlock()
+
+	return emitLimited
+}
+
+func (run *Run) emitCommonPrep(module, fnameBase string, startOffset, startLine int64) (
 	string, string) {
 	if module == "" {
 		module = fnameBase
 	}
 
+	module = run.caseModule(module)
+
 	ol := fmt.Sprintf("%d:%d", startOffset, startLine)
 	ol = (ol + "                ")[0:12]
 
 	return module, ol
 }
 
+// caseModule returns the module canonicalized per the ModuleCase.
+func (run *Run) caseModule(module string) string {
+	switch run.ModuleCase {
+	case "lower":
+		return strings.ToLower(module)
+	case "upper":
+		return strings.ToUpper(module)
+	}
+	return module
+}
+
 func (run *Run) emitCommonLocked(ts, dirBase, fname string, offsetReached int64) {
-	run.fileProgress[dirBase][fname] = offsetReached
+	if len(run.gzipped) <= 0 || !run.gzipped[dirBase+"/"+fname] {
+		run.fileProgress[dirBase][fname] = offsetReached
+	}
 
 	run.emitProgress++
 	if run.ProgressEvery > 0 &&
@@ -438,6 +1996,10 @@ func (run *Run) emitCommonLocked(ts, dirBase, fname string, offsetReached int64)
 		run.emitProgressBarsLocked()
 	}
 
+	if ts == tsNone {
+		return
+	}
+
 	if run.minTS == "" || run.minTS > ts {
 		run.minTS = ts
 	}
@@ -471,8 +2033,11 @@ func (run *Run) emitProgressBarsLocked() {
 			fsize := fileSizes[fname]
 
 			pct := 0.0
-			if fileProgress != nil {
+			if fileProgress != nil && fsize > 0 {
 				pct = float64(fileProgress[fname]) / float64(fsize)
+				if pct > 1.0 {
+					pct = 1.0
+				}
 			}
 
 			fnameOut := (dirBase + "/" + fname + run.spaces)[0:run.maxFNameOutLen]
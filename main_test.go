@@ -0,0 +1,124 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// runFixture processes the testdata dirs, like "testdata/json", with
+// the command-line args, returning what was emitted to stdout.
+func runFixture(t testing.TB, args ...string) string {
+	t.Helper()
+
+	run, _ := parseArgsToRun(append([]string{"mortimint"}, args...))
+
+	var out bytes.Buffer
+	run.addEmitter(run.EmitParts, run.EmitTypes, run.EmitFormat, &out)
+
+	run.processDirs()
+
+	return out.String()
+}
+
+// expectLines fails the test when an expected line isn't emitted,
+// where the lines are compared with their runs of spaces collapsed.
+func expectLines(t *testing.T, out string, expected ...string) {
+	t.Helper()
+
+	var lines []string
+	for _, line := range strings.Split(out, "\n") {
+		lines = append(lines, strings.Join(strings.Fields(line), " "))
+	}
+
+	for _, e := range expected {
+		found := false
+		for _, line := range lines {
+			found = found || line == e
+		}
+		if !found {
+			t.Errorf("expected line: %q, got:\n%s", e, out)
+		}
+	}
+}
+
+func TestURLDir(t *testing.T) {
+	for u, exp := range map[string]string{
+		"http://host/cbcollect_info_ns_1@a/memcached.log":           "http://host/cbcollect_info_ns_1@a",
+		"http://host/cbcollect_info_ns_1@a/memcached.log?sig=a/b":   "http://host/cbcollect_info_ns_1@a",
+		"https://host:8091/logs/x/memcached.log?sig=1&e=2#fragment": "https://host:8091/logs/x",
+	} {
+		if got := urlDir(u); got != exp {
+			t.Errorf("urlDir(%q): %q, expected: %q", u, got, exp)
+		}
+	}
+}
+
+func TestAnonymizeDirBase(t *testing.T) {
+	run, _ := parseArgsToRun([]string{"mortimint", "-anonymize", "-anonymizeSalt", "s",
+		"-emitParts", "FILE,FULL,VALS", "-emitFormat", "json",
+		"testdata/anon/cbcollect_info_ns_1@10.0.0.3_20160414-231044"})
+
+	var out bytes.Buffer
+	run.addEmitter(run.EmitParts, run.EmitTypes, run.EmitFormat, &out)
+
+	var dirs []string
+	run.EntryCallback = func(entry Entry) { dirs = append(dirs, entry.Dir) }
+
+	run.processDirs()
+
+	if !strings.Contains(out.String(), `"kind":"FILE"`) ||
+		!strings.Contains(out.String(), `"kind":"FULL"`) {
+		t.Fatalf("expected FILE and FULL records, got:\n%s", out.String())
+	}
+	if strings.Contains(out.String(), "10.0.0.3") {
+		t.Errorf("expected the dir to be anonymized, got:\n%s", out.String())
+	}
+	if len(dirs) != 1 || strings.Contains(dirs[0], "10.0.0.3") {
+		t.Errorf("expected the entry's dir to be anonymized, got: %q", dirs)
+	}
+}
+
+func TestAnonymizeStderr(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	runFixture(t, "-anonymize", "-anonymizeSalt", "s", "-explain", "12:5", "-tokenizerDebug",
+		"testdata/anon/cbcollect_info_ns_1@10.0.0.3_20160414-231044")
+
+	w.Close()
+	os.Stderr = stderr
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(b), "processing ") ||
+		!strings.Contains(string(b), "explain ") ||
+		!strings.Contains(string(b), "tokenizer ") {
+		t.Fatalf("expected the progress, explain and tokenizer traces, got:\n%s", b)
+	}
+	if strings.Contains(string(b), "10.0.0.3") {
+		t.Errorf("expected the dir to be anonymized, got:\n%s", b)
+	}
+}
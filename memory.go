@@ -0,0 +1,55 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+)
+
+// MemoryCheckInterval bounds how often the heap is measured for the
+// MaxMemory param, as runtime.ReadMemStats stops the world.
+var MemoryCheckInterval = 100 * time.Millisecond
+
+// memoryPressure returns true when the heap has reached 90% of the
+// MaxMemory, so that the features that buffer entries should flush
+// them early, where the result of a check is reused for the
+// MemoryCheckInterval.
+func (run *Run) memoryPressure() bool {
+	if run.MaxMemory <= 0 {
+		return false
+	}
+
+	run.memM.Lock()
+	defer run.memM.Unlock()
+
+	if time.Since(run.memCheckedAt) < MemoryCheckInterval {
+		return run.memPressured
+	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	run.memCheckedAt = time.Now()
+
+	pressured := ms.HeapAlloc >= uint64(run.MaxMemory)/10*9
+	if pressured && !run.memPressured {
+		fmt.Fprintf(os.Stderr, "maxMemory nearly reached, heap: %d bytes,"+
+			" flushing buffered entries early\n", ms.HeapAlloc)
+	}
+
+	run.memPressured = pressured
+
+	return pressured
+}
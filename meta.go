@@ -13,7 +13,12 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"unicode"
 )
@@ -25,6 +30,135 @@ type FileMeta struct {
 	EntryStart func(line string) bool // Optional, returns true when line starts a new log entry.
 	EntryRE    *regexp.Regexp         // Used to parse the first line of a log entry.
 	Cleanser   func([]byte) []byte    // Optional, called before tokenizing an entry.
+
+	// Optional, alternate regexps that are tried in order when the
+	// EntryRE doesn't match, such as for a different field order.
+	EntryREs []*regexp.Regexp
+
+	// Optional, the regexp that the last line of the header must
+	// match, as checked by the StrictHeader param, which defaults to
+	// CBCollectHeaderRE when there's a HeaderSize.
+	HeaderRE *regexp.Regexp
+
+	// Optional, the template, for regexp.Expand(), of the timestamp of
+	// an entry that's matched by a given EntryRE or one of the EntryREs,
+	// so that each timestamp style of a file that mixes styles has its
+	// own mapping of named groups, where the default is TSTemplate.
+	TSTemplates map[*regexp.Regexp]string
+
+	// Optional, the module and level of entries whose EntryRE doesn't
+	// capture a module or level, where the module otherwise defaults
+	// to the fnameBase of the file.
+	DefaultModule string
+	DefaultLevel  string
+
+	// Optional, returns the module of an entry from its first line,
+	// which is cheaper than EntryRE, or "" when it's unknown.
+	ModuleOf func(line string) string
+
+	// When true, every line is an entry, ignoring any EntryStart.
+	LineMode bool
+
+	// When > 0, the lines of an entry beyond this many lines are
+	// dropped, which bounds the memory used by a huge entry.
+	MaxEntryLines int
+
+	// Optional, like "INFO", where entries of a lower level are
+	// skipped, regardless of a lower MinLevel param.
+	MinLevel string
+
+	// When true, entries are separated by blank lines, ignoring any
+	// EntryStart, where an entry's first line is parsed by EntryRE.
+	BlankLineDelimited bool
+
+	// When true, JSON objects in entries are parsed as JSON, as with
+	// the ParseJSON param.
+	ParseJSON bool
+
+	// Optional, called in order on the cleansed buf of an entry.
+	Extractors []Extractor
+
+	// When true, an entry whose first line has logfmt key=value fields,
+	// including a well-known time field like _time, is parsed by its
+	// keys, regardless of the order of its fields, before the EntryRE.
+	Logfmt bool
+
+	// Optional, when non-"", the file is not line oriented and its
+	// entries are instead read by a specialized reader, like "journal"
+	// or "json-stream", which reads successive, possibly multi-line,
+	// JSON objects.
+	Tokenizer string
+}
+
+// matchEntry returns the first of the EntryRE and the EntryREs that
+// matches the first line of an entry, along with its submatch index.
+func (fm *FileMeta) matchEntry(line string) (*regexp.Regexp, []int) {
+	if fm.EntryRE != nil {
+		matchIndex := fm.EntryRE.FindStringSubmatchIndex(line)
+		if matchIndex != nil {
+			return fm.EntryRE, matchIndex
+		}
+	}
+
+	for _, re := range fm.EntryREs {
+		matchIndex := re.FindStringSubmatchIndex(line)
+		if matchIndex != nil {
+			return re, matchIndex
+		}
+	}
+
+	return nil, nil
+}
+
+// TSTemplate is the default template, for regexp.Expand(), of the
+// timestamp of an entry, from the named groups of an EntryRE.
+const TSTemplate = "${year}-${month}-${day}T${HH}:${MM}:${SS}.${SSSS}"
+
+// tsTemplate returns the timestamp template for an entry regexp.
+func (fm *FileMeta) tsTemplate(re *regexp.Regexp) string {
+	if t, exists := fm.TSTemplates[re]; exists {
+		return t
+	}
+	return TSTemplate
+}
+
+// matchesEntry returns true when the EntryRE or one of the EntryREs
+// matches the first line of an entry, or when it's a Logfmt entry.
+func (fm *FileMeta) matchesEntry(line string) bool {
+	if fm.Logfmt && isLogfmtEntry(line) {
+		return true
+	}
+
+	if fm.EntryRE != nil && fm.EntryRE.MatchString(line) {
+		return true
+	}
+
+	for _, re := range fm.EntryREs {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ------------------------------------------------------------
+
+// From the header that cbcollect_info writes at the top of each
+// file, whose last line is a separator...
+//
+//	memcached.log
+//	==============================================================================
+//	cbbrowse_logs memcached.log
+//	==============================================================================
+var CBCollectHeaderRE = regexp.MustCompile(`^={10,}\s*$`)
+
+// headerRE returns the regexp of the last line of a file's header.
+func (fm *FileMeta) headerRE() *regexp.Regexp {
+	if fm.HeaderRE != nil || fm.HeaderSize <= 0 {
+		return fm.HeaderRE
+	}
+	return CBCollectHeaderRE
 }
 
 // ------------------------------------------------------------
@@ -32,6 +166,10 @@ type FileMeta struct {
 // From memcached.log...
 //   2016-04-14T16:10:09.463447-07:00 WARNING Restarting file logging
 //
+// From a level-first variant of the usual format...
+//   WARNING 2016-04-14T16:10:09.463447-07:00 Restarting file logging
+//   [Info] 2016-04-05T13:22:26.133-07:00 pram[:9999] registered /adminport/vbmapRequest
+//
 // From ns_server.fts.log...
 //   2016-04-14T17:43:52.164-07:00 [INFO] moss_herder: persistence progess, waiting: 3
 //
@@ -40,6 +178,8 @@ type FileMeta struct {
 //
 // From ns_server.goxdcr.log...
 //   ReplicationManager 2016-04-14T16:10:09.652-07:00 [INFO] GOMAXPROCS=4
+//   ns_memcached-default 2016-04-14T16:10:09.652-07:00 [INFO] connected
+//   go.xdcr 2016-04-14T16:10:09.652-07:00 [INFO] started
 //
 // From ns_server.http_access.log...
 //   172.23.123.146 - Administrator [14/Apr/2016:16:10:19 -0700] \
@@ -62,15 +202,39 @@ type FileMeta struct {
 //   2016-04-05T13:22:26.133+01:00 [Info] pram[:9999] registered /adminport/vbmapRequest
 
 var ymd = `(?P<year>\d\d\d\d)-(?P<month>\d\d)-(?P<day>\d\d)`
-var hms = `T(?P<HH>\d\d):(?P<MM>\d\d):(?P<SS>\d\d)\.(?P<SSSS>\d+)`
+
+// The fractional seconds may have any number of digits, and may use
+// a ',' as the separator, like "23:10:31,209", as in some locales.
+var hms = `T(?P<HH>\d\d):(?P<MM>\d\d):(?P<SS>\d\d)[.,](?P<SSSS>\d+)`
+
+// The optional timezone, like "-07:00", "+01:00" or "Z".
+var tz = `(?:Z|[-+][0-9:]+)?`
 
 var re_ymd_hms = regexp.MustCompile(" " + ymd + hms + " ")
 
-var re_usual = regexp.MustCompile(`^` + ymd + hms + `-\S+\s(?P<level>\S+)\s`)
+var re_usual = regexp.MustCompile(`^` + ymd + hms + tz + `\s(?P<level>\S+)\s`)
 
-var re_usual_ex = regexp.MustCompile(`^(?P<module>\w+)\s` + ymd + hms + `-\S+\s(?P<level>\S+)\s`)
+var re_usual_level_first = regexp.MustCompile(`^(?P<level>\[?[A-Za-z]+\]?)\s` + ymd + hms + tz + `\s`)
 
-var re_ns = regexp.MustCompile(`^\[(?P<module>\w+):(?P<level>\w+),` + ymd + hms + `-[^,]+,`)
+var re_usual_ex = regexp.MustCompile(`^(?P<module>\w[\w.-]*)\s` + ymd + hms + tz + `\s(?P<level>\S+)\s`)
+
+// From query, where the "_time=" style names its fields...
+//
+//	_time=2016-04-05T13:23:05.378+01:00 _level=INFO _msg=Created New Bucket default
+var re_query_kv = regexp.MustCompile(`^_time=(?P<date>\d\d\d\d-\d\d-\d\d)` +
+	`T(?P<time>\d\d:\d\d:\d\d)(?:[.,](?P<SSSS>\d+))?` + tz +
+	`\s+_level=(?P<level>\S+)\s+(?:_msg=)?`)
+
+// From query, where the Go log package's style has a slash date and
+// usually no fractional seconds, which then default to ".000"...
+//
+//	2016/04/05 13:23:05  Trying with http://127.0.0.1:8091/pools/default/bucketsStreaming/default
+//	2016/04/05 13:23:05.123456 [Warn] slow bucket streaming
+var re_slash_date = regexp.MustCompile(`^(?P<year>\d\d\d\d)/(?P<month>\d\d)/(?P<day>\d\d)` +
+	` (?P<HH>\d\d):(?P<MM>\d\d):(?P<SS>\d\d)(?:[.,](?P<SSSS>\d+))?` +
+	`\s+(?:(?P<level>\[[A-Za-z]+\])\s+)?`)
+
+var re_ns = regexp.MustCompile(`^\[(?P<module>\w+):(?P<level>\w+),` + ymd + hms + tz + `,`)
 
 // ------------------------------------------------------------
 
@@ -92,9 +256,370 @@ var ns_pid_re = regexp.MustCompile(`<\d+\.\d+\.\d+>`) // <0.0.0>
 
 // ------------------------------------------------------------
 
+// The erlang constructs that go's scanner mishandles, as it's a go
+// tokenizer, where refs, funs, ports, binaries and function arities
+// become strings, the '#' of records and maps is dropped, and quoted
+// atoms become strings, instead of ILLEGAL tokens or malformed chars.
+//
+// From ns_server...
+//
+//	{'EXIT',<0.123.0>,killed} in #Ref<0.0.1.234> from #Port<0.5678>
+//	#Fun<ns_janitor.12.345678> and fun ns_janitor:cleanup/2
+//	{state,#{bucket => <<"default">>},#config{rev = 3},<<1,2,3>>}
+//	{'ns_1@10.0.0.2',stale}, where re_addr already stringified the atom
+var re_erl_opaque = regexp.MustCompile(`#(?:Ref|Fun|Port)< ?"?[^<>\s"]*"? ?>`)
+
+var re_erl_fun = regexp.MustCompile(`(?:\bfun\s+(?:\w+:)?\w+|\b\w+:\w+)/\d+\b`)
+
+var re_erl_binary_string = regexp.MustCompile(`<<"([^"\\]*)">>`)
+
+var re_erl_binary = regexp.MustCompile(`<<[\d,\s]*>>`)
+
+var re_erl_hash = regexp.MustCompile(`#(\w*)\{`)
+
+var re_erl_atom = regexp.MustCompile(`' ?"?([^'"\s\\,]+)"? ?'`)
+
+// erlangCleanse neutralizes the erlang syntax of an entry for go's
+// scanner, to be used after any other stringification by a cleanser,
+// so a ref that re_addr already stringified is unquoted again.
+func erlangCleanse(s []byte) []byte {
+	s = re_erl_opaque.ReplaceAllFunc(s, func(ref []byte) []byte {
+		ref = bytes.Replace(ref, []byte(`"`), nil, -1)
+		ref = bytes.Replace(ref, []byte(" "), nil, -1)
+		return []byte(` "` + string(ref) + `" `)
+	})
+	s = re_erl_fun.ReplaceAll(s, stringify_replace)
+	s = re_erl_binary_string.ReplaceAll(s, []byte(` "$1" `))
+	s = re_erl_binary.ReplaceAll(s, stringify_replace)
+	s = re_erl_hash.ReplaceAll(s, []byte(`$1{`))
+	s = re_erl_atom.ReplaceAll(s, []byte(`"$1"`))
+	return s
+}
+
+// ------------------------------------------------------------
+
+// ErrorSignatures are matched against a cleansed entry to find
+// entries that report an error, regardless of their level; for
+// example, an INFO entry with an erlang {error,...} tuple.
+var ErrorSignatures = []*regexp.Regexp{
+	regexp.MustCompile(`\{\s*error\s*,`),                                      // {error,enoent}
+	regexp.MustCompile(`\bbadmatch\b`),                                        // {badmatch,...}
+	regexp.MustCompile(`\bHTTP/\d\.\d"?\s+[45]\d\d\b`),                        // "GET / HTTP/1.1" 500
+	regexp.MustCompile(`(?i)\bstatus(?:[ _]?code)?"?\s*[:=]?\s*"?[45]\d\d\b`), // status: 404
+}
+
+func hasErrorSignature(s []byte) bool {
+	for _, re := range ErrorSignatures {
+		if re.Match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ------------------------------------------------------------
+
+// FilenameLevels maps the fnameBase of files whose name encodes a
+// level, like the "error" of "ns_server.error.log", to the level of
+// their entries whose level can't be parsed, for LevelFromFilename.
+var FilenameLevels = map[string]string{
+	"critical":         "CRIT",
+	"debug":            "DEBUG",
+	"error":            "ERRO",
+	"info":             "INFO",
+	"mapreduce_errors": "ERRO",
+	"warn":             "WARN",
+	"xdcr_errors":      "ERRO",
+}
+
+// loadFilenameLevels returns the FilenameLevels, with levels added or
+// replaced by a comma-separated list of fnameBase=level pairs, like
+// "error=ERROR,babysitter=INFO".
+func loadFilenameLevels(pairs string) (map[string]string, error) {
+	rv := map[string]string{}
+	for fnameBase, level := range FilenameLevels {
+		rv[fnameBase] = level
+	}
+
+	for _, pair := range strings.Split(pairs, ",") {
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("expected fnameBase=level, got: %q", pair)
+		}
+
+		rv[kv[0]] = normalizeLevel(kv[1])
+	}
+
+	return rv, nil
+}
+
+// ServicePorts maps the well-known ports of Couchbase Server to the
+// names of their services, for ServiceFromPort.
+var ServicePorts = map[string]string{
+	"4369":  "epmd",
+	"8091":  "rest",
+	"8092":  "capi",
+	"8093":  "query",
+	"8094":  "fts",
+	"8095":  "analytics",
+	"8096":  "eventing",
+	"9100":  "indexer_admin",
+	"9101":  "indexer_scan",
+	"9102":  "indexer_http",
+	"9999":  "projector",
+	"11207": "kv_ssl",
+	"11209": "kv_internal",
+	"11210": "kv",
+	"18091": "rest_ssl",
+	"18092": "capi_ssl",
+	"18093": "query_ssl",
+	"21100": "ns_server_dist",
+}
+
+// loadServicePorts returns the ServicePorts, with services added or
+// replaced by a comma-separated list of port=service pairs, like
+// "8091=ns_server,9000=proxy".
+func loadServicePorts(pairs string) (map[string]string, error) {
+	rv := map[string]string{}
+	for port, service := range ServicePorts {
+		rv[port] = service
+	}
+
+	for _, pair := range strings.Split(pairs, ",") {
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("expected port=service, got: %q", pair)
+		}
+
+		if _, err := strconv.Atoi(kv[0]); err != nil {
+			return nil, fmt.Errorf("expected a numeric port, got: %q", pair)
+		}
+
+		rv[kv[0]] = kv[1]
+	}
+
+	return rv, nil
+}
+
+// ------------------------------------------------------------
+
+// An EventSignature labels the entries that match its RE with an
+// event_type, such as to build a timeline of cluster-wide events.
+type EventSignature struct {
+	EventType string
+	RE        *regexp.Regexp
+}
+
+// EventSignatures are matched against a cleansed entry, where more
+// signatures may be added or overridden by the EventSignatures param.
+var EventSignatures = []EventSignature{
+	{"failover", regexp.MustCompile(`(?i)\bfail(?:ed)?[ _]?over\b`)},
+	{"node_down", regexp.MustCompile(`\bnodedown\b`)},
+	{"orchestrator_change", regexp.MustCompile(
+		`(?i)\b(?:new orchestrator|orchestrator (?:change|moved)|mb_master\b.*\bmaster\b|` +
+			`I'm the master|so I'm taking over)`)},
+	{"rebalance_end", regexp.MustCompile(
+		`(?i)\brebalance (?:completed|exited|failed|stopped)\b`)},
+	{"rebalance_start", regexp.MustCompile(
+		`(?i)\b(?:starting rebalance|rebalance start(?:ed)?)\b`)},
+}
+
+// loadEventSignatures returns the EventSignatures, with signatures
+// added or replaced by a JSON file that maps event types to regexps,
+// like {"failover": "(?i)failed over"}.
+func loadEventSignatures(path string) ([]EventSignature, error) {
+	rv := append([]EventSignature(nil), EventSignatures...)
+	if path == "" {
+		return rv, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]string
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	eventTypes := make([]string, 0, len(m))
+	for eventType := range m {
+		eventTypes = append(eventTypes, eventType)
+	}
+	sort.Strings(eventTypes)
+
+	for _, eventType := range eventTypes {
+		re, err := regexp.Compile(m[eventType])
+		if err != nil {
+			return nil, fmt.Errorf("event signature: %s, err: %v", eventType, err)
+		}
+
+		es := EventSignature{eventType, re}
+
+		replaced := false
+		for i := range rv {
+			if rv[i].EventType == eventType {
+				rv[i], replaced = es, true
+			}
+		}
+		if !replaced {
+			rv = append(rv, es)
+		}
+	}
+
+	return rv, nil
+}
+
+// ------------------------------------------------------------
+
+// A StatusSignature normalizes the result of the operation of the
+// entries that match its RE into a Status, like "ok" or "error".
+type StatusSignature struct {
+	Status string
+	RE     *regexp.Regexp
+}
+
+// StatusSignatures are matched in order against a cleansed entry,
+// where the first match wins, so the "error" signatures come first,
+// starting with the ErrorSignatures, and an entry that matches none
+// has an "unknown" status.
+var StatusSignatures = append(statusSignaturesOf("error", ErrorSignatures),
+	[]StatusSignature{
+		{"error", regexp.MustCompile(`(?i)\bsuccess"?\s*[:=]\s*"?false\b`)},                    // success: false
+		{"error", regexp.MustCompile(`(?i)\bstatus"?\s*[:=]\s*"?(?:error|fail(?:ed|ure)?)\b`)}, // "status":"failed"
+		{"ok", regexp.MustCompile(`\{\s*ok\s*[,}]`)},                                           // {ok,<0.1.0>}
+		{"ok", regexp.MustCompile(`\bHTTP/\d\.\d"?\s+2\d\d\b`)},                                // "GET / HTTP/1.1" 200
+		{"ok", regexp.MustCompile(`(?i)\bstatus(?:[ _]?code)?"?\s*[:=]?\s*"?2\d\d\b`)},         // status: 200
+		{"ok", regexp.MustCompile(`(?i)\bsuccess"?\s*[:=]\s*"?true\b`)},                        // success: true
+		{"ok", regexp.MustCompile(`(?i)\bstatus"?\s*[:=]\s*"?(?:ok|success)\b`)},               // "status":"success"
+		{"ok", regexp.MustCompile(`(?:^|[\s:])ok\s*$`)},                                        // ...: ok
+	}...)
+
+// statusSignaturesOf returns a StatusSignature of the status for each
+// of the regexps.
+func statusSignaturesOf(status string, res []*regexp.Regexp) []StatusSignature {
+	rv := make([]StatusSignature, 0, len(res))
+	for _, re := range res {
+		rv = append(rv, StatusSignature{status, re})
+	}
+	return rv
+}
+
+// loadStatusSignatures returns the StatusSignatures, where a JSON file
+// that maps statuses to regexps, like {"error": "(?i)\\bfailed\\b"},
+// replaces the signatures of its statuses, or else adds new statuses,
+// which are matched last.
+func loadStatusSignatures(path string) ([]StatusSignature, error) {
+	rv := append([]StatusSignature(nil), StatusSignatures...)
+	if path == "" {
+		return rv, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]string
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]string, 0, len(m))
+	for status := range m {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+
+	for _, status := range statuses {
+		re, err := regexp.Compile(m[status])
+		if err != nil {
+			return nil, fmt.Errorf("status signature: %s, err: %v", status, err)
+		}
+
+		ss := StatusSignature{status, re}
+
+		// The signatures of the status are replaced, in place of the
+		// first of them, so the errors still come first.
+		kept := rv[0:0]
+		replaced := false
+		for _, prev := range rv {
+			if prev.Status != status {
+				kept = append(kept, prev)
+			} else if !replaced {
+				kept, replaced = append(kept, ss), true
+			}
+		}
+		if !replaced {
+			kept = append(kept, ss)
+		}
+
+		rv = kept
+	}
+
+	return rv, nil
+}
+
+// statusOf returns the status of the first StatusSignature that an
+// entry matches, or else "unknown".
+func statusOf(statusSignatures []StatusSignature, s []byte) string {
+	for _, ss := range statusSignatures {
+		if ss.RE.Match(s) {
+			return ss.Status
+		}
+	}
+	return "unknown"
+}
+
+// ------------------------------------------------------------
+
 var FileMetaUsual = FileMeta{
 	HeaderSize: 4,
 	EntryRE:    re_usual,
+	EntryREs:   []*regexp.Regexp{re_usual_level_first},
+	Extractors: []Extractor{extractPaths, extractUnitMetrics, extractQueueMetrics},
+}
+
+// FileMetaIndexer represents metadata about the indexer log.
+var FileMetaIndexer = FileMeta{
+	HeaderSize: 4,
+	EntryRE:    re_usual,
+	EntryREs:   []*regexp.Regexp{re_usual_level_first},
+	Extractors: []Extractor{extractPaths, extractIndexPhase, extractQueueMetrics},
+}
+
+// FileMetaQuery represents metadata about the query log, which mixes
+// timestamp styles, where the _time= style, whose fractional seconds
+// are optional, has its own TSTemplate, though the logfmt parsing
+// handles the _time= style first, whatever the order of its fields;
+// and where the slash date style uses the usual TSTemplate, whose
+// missing fractional seconds are padded to ".000" by tsFit.
+var FileMetaQuery = FileMeta{
+	HeaderSize: 4,
+	Logfmt:     true,
+	EntryRE:    re_usual,
+	EntryREs:   []*regexp.Regexp{re_query_kv, re_usual_level_first, re_slash_date},
+	TSTemplates: map[*regexp.Regexp]string{
+		re_query_kv: "${date}T${time}.${SSSS}",
+	},
+}
+
+var FileMetaProjector = FileMeta{
+	HeaderSize: 4,
+	EntryRE:    re_usual,
+	EntryREs:   []*regexp.Regexp{re_usual_level_first},
+	Extractors: []Extractor{extractRPC, extractPram, extractStreamTopic, extractQueueMetrics},
 }
 
 // FileMetaNS represents metadata about an ns-server log file.
@@ -112,6 +637,14 @@ var FileMetaNS = FileMeta{
 		return unicode.IsDigit(rune(lineParts[1][0]))
 	},
 	EntryRE: re_ns,
+	ModuleOf: func(line string) string {
+		// Ex: "[ns_server:info,2016-04-14T16:10:05.262-07:00,..."
+		colon := strings.IndexByte(line, ':')
+		if len(line) <= 0 || line[0] != '[' || colon < 0 {
+			return ""
+		}
+		return line[1:colon]
+	},
 	Cleanser: func(s []byte) []byte {
 		// Clear out first non-matching ']'.
 		rbrack := bytes.Index(s, []byte("]"))
@@ -138,10 +671,27 @@ var FileMetaNS = FileMeta{
 		// Stringify uuids.
 		s = re_uuid.ReplaceAll(s, stringify_replace)
 
-		return s
+		return erlangCleanse(s)
+	},
+	Extractors: []Extractor{
+		extractErrorReason,
+		extractRebalanceMove,
+		extractOrchestrator,
+		extractLists,
+		extractCrashReport,
+		extractPaths,
+		extractQueueMetrics,
 	},
 }
 
+// FileMetaNSDebug represents metadata about the huge ns_server.debug.log.
+var FileMetaNSDebug = func() FileMeta {
+	fm := FileMetaNS
+	fm.MaxEntryLines = 200
+	fm.MinLevel = "INFO"
+	return fm
+}()
+
 // ------------------------------------------------------------
 
 // FileMetas is keyed by file name.
@@ -154,23 +704,33 @@ var FileMetas = map[string]FileMeta{ // Keep alphabetical...
 
 	// SKIP: "ini.log" -- not a log file.
 
-	// TODO: "master_events.log".
+	"master_events.log": {
+		DefaultModule: "master_events",
+		DefaultLevel:  "INFO",
+		ParseJSON:     true,
+		Tokenizer:     "json-stream",
+	},
 
 	"memcached.log": {
-		HeaderSize: 4,
-		EntryRE:    re_usual,
+		HeaderSize:    4,
+		DefaultModule: "memcached",
+		EntryRE:       re_usual,
+		EntryREs:      []*regexp.Regexp{re_usual_level_first},
 		Cleanser: func(s []byte) []byte {
 			s = re_addr.ReplaceAll(s, stringify_replace)
 			s = re_uuid.ReplaceAll(s, stringify_replace)
 			return s
 		},
+		Extractors: []Extractor{extractMemcachedConn, extractPaths, extractUnitMetrics, extractQueueMetrics},
 	},
 
 	"ns_server.babysitter.log": FileMetaNS,
 
 	"ns_server.couchdb.log": FileMetaNS,
 
-	// TODO: "ns_server.debug.log": FileMetaNS, -- too big for now.
+	// The debug.log is huge, so it's streamed with bounded entries
+	// and without its DEBUG entries.
+	"ns_server.debug.log": FileMetaNSDebug,
 
 	"ns_server.error.log": FileMetaNS,
 
@@ -188,6 +748,7 @@ var FileMetas = map[string]FileMeta{ // Keep alphabetical...
 	"ns_server.goxdcr.log": {
 		HeaderSize: 4,
 		EntryRE:    re_usual_ex,
+		Extractors: []Extractor{extractRPC, extractQueueMetrics},
 	},
 
 	"ns_server.http_access.log": {
@@ -200,7 +761,7 @@ var FileMetas = map[string]FileMeta{ // Keep alphabetical...
 		HeaderSize: 4,
 	},
 
-	"ns_server.indexer.log": FileMetaUsual,
+	"ns_server.indexer.log": FileMetaIndexer,
 
 	"ns_server.info.log": FileMetaNS,
 
@@ -210,9 +771,9 @@ var FileMetas = map[string]FileMeta{ // Keep alphabetical...
 
 	"ns_server.ns_couchdb.log": FileMetaNS,
 
-	"ns_server.projector.log": FileMetaUsual,
+	"ns_server.projector.log": FileMetaProjector,
 
-	"ns_server.query.log": FileMetaUsual, // TODO: Revisit.
+	"ns_server.query.log": FileMetaQuery,
 
 	"ns_server.reports.log": FileMetaNS,
 
@@ -226,7 +787,11 @@ var FileMetas = map[string]FileMeta{ // Keep alphabetical...
 
 	// TODO: "ns_server.xdcr_errors.log".
 
-	// TODO: "ns_server.xdcr_trace.log".
+	"ns_server.xdcr_trace.log": {
+		HeaderSize: 4,
+		ParseJSON:  true,
+		Tokenizer:  "xdcr_trace",
+	},
 
 	// TODO: "stats.log".
 
@@ -234,5 +799,17 @@ var FileMetas = map[string]FileMeta{ // Keep alphabetical...
 
 	// TODO: "syslog.tar.gz".
 
-	// TODO: "systemd_journal.gz".
+	"systemd_journal.gz": {
+		Tokenizer: "journal",
+	},
+}
+
+// lookupFileMeta returns the FileMeta for a file name, which might
+// have a ".gz" suffix that's not part of its FileMetas key.
+func lookupFileMeta(fname string) (FileMeta, bool) {
+	fmeta, exists := FileMetas[fname]
+	if !exists {
+		fmeta, exists = FileMetas[fileMetaName(fname)]
+	}
+	return fmeta, exists
 }
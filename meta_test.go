@@ -0,0 +1,89 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"go/scanner"
+	"go/token"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestLevelBeforeOrAfterTimestamp(t *testing.T) {
+	out := runFixture(t, "-emitParts", "FULL", "testdata/levels")
+
+	expectLines(t, out,
+		"2016-04-14T16:10:09.463 WARN levels/memcached.log 12:5 memcached vb 22 curr_items=5",
+		"2016-04-14T16:10:10.463 WARN levels/memcached.log 72:6 memcached vb 23 curr_items=6",
+		"2016-04-14T16:10:11.463 INFO levels/memcached.log 132:7 memcached vb 24 curr_items=7")
+}
+
+func TestModulesWithDotsAndHyphens(t *testing.T) {
+	out := runFixture(t, "-emitParts", "FULL", "testdata/modules")
+
+	expectLines(t, out,
+		"2016-04-14T16:10:05.262 INFO modules/ns_server.goxdcr.log 12:5 ns_memcached-default connected curr_items=5",
+		"2016-04-14T16:10:06.262 WARN modules/ns_server.goxdcr.log 91:6 go.xdcr batch took 120ms",
+		"2016-04-14T16:10:07.262 ERRO modules/ns_server.goxdcr.log 151:7 XmemNozzle rpc call failed")
+}
+
+func TestErlangCleanseFixtures(t *testing.T) {
+	for _, fixture := range []string{
+		"testdata/atoms/ns_server.info.log",
+		"testdata/crash/ns_server.info.log",
+	} {
+		data, err := ioutil.ReadFile(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var entries []string
+		for _, line := range strings.Split(string(data), "\n")[FileMetaNS.HeaderSize:] {
+			if strings.HasPrefix(line, "[") || len(entries) <= 0 {
+				entries = append(entries, "")
+			}
+			entries[len(entries)-1] += line + "\n"
+		}
+
+		for _, entry := range entries {
+			src := FileMetaNS.Cleanser([]byte(entry))
+
+			var s scanner.Scanner
+			s.Init(token.NewFileSet().AddFile(fixture, -1, len(src)), src, nil, 0)
+
+			for {
+				_, tok, lit := s.Scan()
+				if tok == token.EOF {
+					break
+				}
+				if tok == token.ILLEGAL || tok == token.CHAR {
+					t.Errorf("fixture: %s, tok: %v, lit: %q, cleansed: %s",
+						fixture, tok, lit, src)
+				}
+			}
+		}
+	}
+}
+
+func TestErlangCleanseAtoms(t *testing.T) {
+	for _, c := range []struct{ in, exp string }{
+		{`are 'down'`, `are "down"`},
+		{`{'EXIT',killed}`, `{"EXIT",killed}`},
+		{`['ns_1@10.0.0.2','ns_1@10.0.0.3']`, `["ns_1@10.0.0.2","ns_1@10.0.0.3"]`},
+	} {
+		got := string(FileMetaNS.Cleanser([]byte(c.in)))
+		if got != c.exp {
+			t.Errorf("in: %s, got: %s, exp: %s", c.in, got, c.exp)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OTLPBatchSize is the max number of log records sent per OTLP/HTTP
+// request.
+var OTLPBatchSize = 500
+
+// OTLPRetries is the number of times a failed OTLP/HTTP request, as
+// from a network error or a 429 or 5xx status, is retried, with a
+// doubling backoff, before its batch is dropped with a warning.
+var OTLPRetries = 3
+
+// OTLPBackoff is the backoff before the first retry of a request.
+var OTLPBackoff = 500 * time.Millisecond
+
+// OTLPPendingBatches is the max number of marshaled batches that are
+// waiting to be sent, beyond which a flush blocks until the sender
+// catches up, as backpressure, rather than the batches piling up in
+// memory or being dropped.
+var OTLPPendingBatches = 4
+
+// otlpClient sends the OTLP/HTTP requests, where the timeout covers a
+// whole request, as the batches are bounded by the OTLPBatchSize.
+var otlpClient = &http.Client{Timeout: 30 * time.Second}
+
+// otlpSeverityNumbers maps the normalized levels to the severity
+// numbers of the OpenTelemetry log data model.
+var otlpSeverityNumbers = map[string]int{
+	"DEBUG": 5,
+	"INFO":  9,
+	"NOTI":  10,
+	"WARN":  13,
+	"ERRO":  17,
+	"CRIT":  21,
+	"ALER":  22,
+	"EMER":  23,
+}
+
+// otlpShipper is an EntryCallback that converts entries to OTLP log
+// records, which are sent in batches as OTLP/HTTP JSON requests to
+// the OTLPEndpoint, as for the "otlp" EmitFormat.
+type otlpShipper struct {
+	endpoint string
+	records  []json.RawMessage
+
+	// The marshaled bytes of the records, which are counted like the
+	// bytes written by the emitters, as for the MaxOutputBytes param.
+	n *int64
+
+	// The marshaled batches are sent by a goroutine, as the entries
+	// arrive while the run.m is held, which mustn't wait on a request,
+	// though a flush blocks once OTLPPendingBatches are waiting.
+	sendCh chan otlpBatch
+	doneCh chan struct{}
+
+	sent    int // Log records sent, which is owned by the goroutine.
+	dropped int // Log records dropped, which is owned by the goroutine.
+}
+
+// An otlpBatch is a marshaled request of n log records.
+type otlpBatch struct {
+	n    int
+	body []byte
+}
+
+// newOTLPShipper returns an otlpShipper, whose goroutine sends the
+// batches to the endpoint until the otlpShipper is done, and which
+// adds the marshaled bytes of the records to n.
+func newOTLPShipper(endpoint string, n *int64) *otlpShipper {
+	s := &otlpShipper{
+		endpoint: endpoint,
+		n:        n,
+		sendCh:   make(chan otlpBatch, OTLPPendingBatches),
+		doneCh:   make(chan struct{}),
+	}
+
+	go s.sender()
+
+	return s
+}
+
+// entry converts an Entry to an OTLP log record, where the entry's
+// lines become the body and its VALS parts become attributes.
+func (s *otlpShipper) entry(entry Entry) {
+	record := map[string]interface{}{
+		"severityText": entry.Level,
+		"body": map[string]interface{}{
+			"stringValue": strings.Join(entry.Lines, "\n"),
+		},
+	}
+
+	if n, exists := otlpSeverityNumbers[entry.Level]; exists {
+		record["severityNumber"] = n
+	}
+
+	if t, err := parseTS(entry.TS); err == nil && entry.TS != tsNone {
+		record["timeUnixNano"] = strconv.FormatInt(t.UnixNano(), 10)
+	}
+
+	attrs := []interface{}{
+		otlpAttr("module", "STRING", entry.Module),
+		otlpAttr("log.file.path", "STRING", entry.Dir+"/"+entry.File),
+		otlpAttr("log.offset", "INT", strconv.FormatInt(entry.Offset, 10)),
+	}
+
+	if entry.Confidence != "" {
+		attrs = append(attrs, otlpAttr("confidence", "FLOAT", entry.Confidence))
+	}
+
+	for _, part := range entry.Parts {
+		if part.Kind == "VALS" {
+			key := strings.Join(append(append([]string(nil), part.Path...), part.Name), ".")
+
+			attrs = append(attrs, otlpAttr(key, part.Type, part.Val))
+		}
+	}
+
+	record["attributes"] = attrs
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		log.Fatalf("error: otlp marshal: %v", err)
+	}
+	*s.n += int64(len(b))
+
+	s.records = append(s.records, b)
+	if len(s.records) >= OTLPBatchSize {
+		s.flush()
+	}
+}
+
+// otlpAttr returns an OTLP attribute of an emitted value, where the
+// INT, FLOAT and BOOL value types keep their OTLP types.
+func otlpAttr(key, valType, val string) map[string]interface{} {
+	var v map[string]interface{}
+
+	switch valType {
+	case "INT":
+		v = map[string]interface{}{"intValue": val}
+	case "FLOAT":
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			v = map[string]interface{}{"doubleValue": f}
+		}
+	case "BOOL":
+		v = map[string]interface{}{"boolValue": val == "true"}
+	}
+
+	if v == nil {
+		if unquoted, err := strconv.Unquote(val); err == nil {
+			val = unquoted
+		}
+
+		v = map[string]interface{}{"stringValue": val}
+	}
+
+	return map[string]interface{}{"key": key, "value": v}
+}
+
+// flush sends the batched log records to the endpoint, blocking when
+// OTLPPendingBatches are already waiting to be sent.
+func (s *otlpShipper) flush() {
+	if len(s.records) <= 0 {
+		return
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceLogs": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []interface{}{
+					otlpAttr("service.name", "STRING", "mortimint"),
+				},
+			},
+			"scopeLogs": []interface{}{map[string]interface{}{
+				"scope":      map[string]interface{}{"name": "mortimint"},
+				"logRecords": s.records,
+			}},
+		}},
+	})
+	if err != nil {
+		log.Fatalf("error: otlp marshal: %v", err)
+	}
+
+	s.sendCh <- otlpBatch{n: len(s.records), body: body}
+
+	s.records = s.records[0:0]
+}
+
+// sender posts the batches from the sendCh until it's closed, where
+// a batch that still fails after the OTLPRetries is dropped.
+func (s *otlpShipper) sender() {
+	defer close(s.doneCh)
+
+	for batch := range s.sendCh {
+		backoff := OTLPBackoff
+
+		for attempt := 0; ; attempt++ {
+			retry, err := s.post(batch.body)
+			if err == nil {
+				s.sent += batch.n
+				break
+			}
+
+			if !retry || attempt >= OTLPRetries {
+				fmt.Fprintf(os.Stderr, "warning: otlp dropped %d log records, err: %v\n",
+					batch.n, err)
+				s.dropped += batch.n
+				break
+			}
+
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// post sends a batch's body to the endpoint, returning whether a
+// failed request is worth a retry.
+func (s *otlpShipper) post(body []byte) (bool, error) {
+	resp, err := otlpClient.Post(s.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, fmt.Errorf("otlp post: %s, err: %v", s.endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5,
+			fmt.Errorf("otlp post: %s, status: %s, body: %s", s.endpoint, resp.Status, msg)
+	}
+
+	io.Copy(ioutil.Discard, resp.Body) // So the connection is reused.
+
+	return false, nil
+}
+
+// done sends any remaining log records, and reports the total.
+func (s *otlpShipper) done() string {
+	s.flush()
+
+	close(s.sendCh)
+	<-s.doneCh
+
+	return fmt.Sprintf("otlp: sent %d log records to %s, dropped: %d",
+		s.sent, s.endpoint, s.dropped)
+}
@@ -0,0 +1,86 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOTLPShipperRetries(t *testing.T) {
+	var m sync.Mutex
+	var statuses []int // The statuses of the requests, in order.
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			m.Lock()
+			defer m.Unlock()
+
+			status := http.StatusOK
+			switch len(statuses) {
+			case 0:
+				status = http.StatusServiceUnavailable // Retried.
+			case 2:
+				status = http.StatusBadRequest // Dropped.
+			}
+			statuses = append(statuses, status)
+
+			w.WriteHeader(status)
+		}))
+	defer server.Close()
+
+	defer func(backoff time.Duration) { OTLPBackoff = backoff }(OTLPBackoff)
+	OTLPBackoff = time.Millisecond
+
+	var n int64
+
+	s := newOTLPShipper(server.URL, &n)
+
+	for i := 0; i < 3; i++ {
+		s.entry(Entry{TS: "2016-04-14T16:10:09.463", Level: "WARN",
+			Lines: []string{"vb 22"}})
+		s.flush()
+	}
+
+	report := s.done()
+	if !strings.Contains(report, "sent 2 log records") ||
+		!strings.Contains(report, "dropped: 1") {
+		t.Errorf("expected 2 sent and 1 dropped, got: %s, statuses: %v",
+			report, statuses)
+	}
+}
+
+func TestOTLPMaxOutputBytes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	run, _ := parseArgsToRun([]string{"mortimint", "-emitFormat", "otlp",
+		"-otlpEndpoint", server.URL, "-maxOutputBytes", "1", "testdata/restart"})
+
+	s := newOTLPShipper(run.OTLPEndpoint, &run.emitBytes)
+	run.EntryCallback = s.entry
+
+	run.processDirs()
+
+	// An entry's record is marshaled once the file's next entry starts,
+	// so the first record reaches the maxOutputBytes after the second
+	// entry, which is the last of the 5 entries that's sent.
+	report := s.done()
+	if !strings.Contains(report, "sent 2 log records") || run.emitBytes <= 1 {
+		t.Errorf("expected 2 records sent, got: %s, bytes: %d", report, run.emitBytes)
+	}
+}
@@ -0,0 +1,167 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"sync"
+)
+
+// An entryPipeline decouples the scanning of a file into entries
+// from the CPU-heavy cleansing, tokenizing and emitting of entries,
+// which are done concurrently by a pool of worker fileProcessor
+// clones, whose captured emits are invoked in the entries' original
+// order through a reorder buffer.
+type entryPipeline struct {
+	p      *fileProcessor
+	clones []*fileProcessor
+
+	seq int64 // Sequence number of the next entry.
+
+	slotsCh chan struct{}       // Bounds the entries that are in flight.
+	workCh  chan *pipelineEntry // Entries to be processed by the workers.
+	doneCh  chan *pipelineEntry // Processed entries, in any order.
+
+	workersWG  sync.WaitGroup
+	reorderEnd chan struct{}
+}
+
+// A pipelineEntry is an entry with its own copy of lines, along with
+// the emits that were captured while processing the entry.
+type pipelineEntry struct {
+	seq                    int64
+	startOffset, startLine int64
+	lines                  []string
+	emits                  []func()
+}
+
+// startEntryPipeline starts the workers and reorder goroutines of an
+// entryPipeline, which must be finished via finish().
+func (p *fileProcessor) startEntryPipeline(workers int) *entryPipeline {
+	ep := &entryPipeline{
+		p:          p,
+		slotsCh:    make(chan struct{}, workers*16),
+		workCh:     make(chan *pipelineEntry, workers*2),
+		doneCh:     make(chan *pipelineEntry, workers*2),
+		reorderEnd: make(chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		clone := *p
+		clone.dict = Dict{}
+		clone.entriesParsed = 0
+		clone.levelCounts = nil
+		clone.buf = nil
+		clone.captureEmits = true
+
+		ep.clones = append(ep.clones, &clone)
+
+		ep.workersWG.Add(1)
+		go ep.worker(&clone)
+	}
+
+	go ep.reorder()
+
+	return ep
+}
+
+// processEntry sends an entry to the workers, blocking when too many
+// entries are already in flight, where the lines are owned by the
+// pipeline from then on, so the caller mustn't reuse the lines slice.
+func (ep *entryPipeline) processEntry(startOffset, startLine int64, lines []string) {
+	if startLine <= 0 || len(lines) <= 0 {
+		return
+	}
+
+	ep.slotsCh <- struct{}{}
+
+	ep.workCh <- &pipelineEntry{
+		seq:         ep.seq,
+		startOffset: startOffset,
+		startLine:   startLine,
+		lines:       lines,
+	}
+
+	ep.seq++
+}
+
+func (ep *entryPipeline) worker(clone *fileProcessor) {
+	for e := range ep.workCh {
+		clone.emits = nil
+		clone.processEntry(e.startOffset, e.startLine, e.lines)
+		e.emits = clone.emits
+
+		ep.doneCh <- e
+	}
+
+	ep.workersWG.Done()
+}
+
+// reorder invokes the captured emits of the processed entries in
+// the order of their sequence numbers.
+func (ep *entryPipeline) reorder() {
+	pending := map[int64]*pipelineEntry{}
+
+	var next int64
+
+	for e := range ep.doneCh {
+		pending[e.seq] = e
+
+		for {
+			e, exists := pending[next]
+			if !exists {
+				break
+			}
+
+			for _, emit := range e.emits {
+				emit()
+			}
+
+			delete(pending, next)
+			next++
+
+			<-ep.slotsCh
+		}
+	}
+
+	close(ep.reorderEnd)
+}
+
+// finish waits for all the entries to be processed and emitted, and
+// then merges the dicts and counts of the worker clones into the
+// fileProcessor.
+func (ep *entryPipeline) finish() {
+	close(ep.workCh)
+	ep.workersWG.Wait()
+
+	close(ep.doneCh)
+	<-ep.reorderEnd
+
+	dictFull := ep.p.dictFull
+
+	for _, clone := range ep.clones {
+		if !clone.dict.AddTo(ep.p.dict, ep.p.run.DictSamples, ep.p.run.MaxDictSize) ||
+			clone.dictFull {
+			ep.p.dictFull = true
+		}
+		ep.p.entriesParsed += clone.entriesParsed
+		if clone.levelCounts != nil {
+			if ep.p.levelCounts == nil {
+				ep.p.levelCounts = map[string]map[string]int64{}
+			}
+			addLevelCounts(ep.p.levelCounts, clone.levelCounts)
+		}
+	}
+
+	if ep.p.dictFull && !dictFull {
+		ep.p.warnDictFull()
+	}
+}
@@ -0,0 +1,94 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestEntryWorkersNamePaths(t *testing.T) {
+	exp := runFixture(t, "-emitParts", "VALS", "testdata/nested")
+
+	expectLines(t, exp,
+		"2016-04-14T16:10:10.000 NOTI nested/memcached.log 12:5 memcached cfg.a.b.c d = INT 1",
+		"2016-04-14T16:10:10.000 NOTI nested/memcached.log 12:5 memcached cfg.a.b.f h = INT 4")
+
+	out := runFixture(t, "-emitParts", "VALS", "-entryWorkers", "4", "testdata/nested")
+	if out != exp {
+		t.Errorf("expected the same as without entryWorkers:\n%s\ngot:\n%s", exp, out)
+	}
+}
+
+func TestEntryWorkersDictFull(t *testing.T) {
+	run, _ := parseArgsToRun([]string{"mortimint", "-maxDictSize", "2",
+		"-entryWorkers", "4", "testdata/nested"})
+	run.processDirs()
+
+	fp := run.fileProcessors["nested"]["memcached.log"]
+	if !fp.dictFull || !run.dictFull {
+		t.Errorf("expected the file's and the run's dicts to be truncated,"+
+			" got: %t, %t", fp.dictFull, run.dictFull)
+	}
+}
+
+// TestEntryWorkersRace runs the entryWorkers over many entries of a few
+// files at once, which is meant for go test -race, as the entries'
+// lines and the namePaths mustn't be shared with the reused buffers.
+func TestEntryWorkersRace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mortimint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, fname := range []string{"memcached.log", "ns_server.info.log"} {
+		data, err := ioutil.ReadFile("testdata/bench/" + fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.SplitAfter(string(data), "\n")
+
+		// The entries are repeated after the 4 header lines.
+		data = []byte(strings.Join(lines[0:4], "") +
+			strings.Repeat(strings.Join(lines[4:], ""), 200))
+
+		err = ioutil.WriteFile(filepath.Join(dir, fname), data, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sortedLines := func(out string) string {
+		lines := strings.Split(out, "\n")
+		sort.Strings(lines)
+		return strings.Join(lines, "\n")
+	}
+
+	exp := sortedLines(runFixture(t, "-emitParts", "FULL,VALS", "-workers", "1", dir))
+
+	for _, args := range [][]string{
+		{"-entryWorkers", "4", "-workers", "1"},
+		{"-entryWorkers", "4", "-workers", "2"},
+		{"-entryWorkers", "4", "-workers", "2", "-bufferReuse=false"},
+	} {
+		out := runFixture(t, append(append([]string{"-emitParts", "FULL,VALS"}, args...), dir)...)
+		if sortedLines(out) != exp {
+			t.Errorf("args: %v, expected the same entries as serially", args)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+)
+
+// A Rule tags the entries that match all of its non-"" conditions,
+// where a rules file is a JSON array of rules, like...
+//
+//	[{"tag": "network", "level": "ERROR", "contains": "timeout"},
+//	 {"tag": "auth", "module": "ns_server", "re": "(?i)\\bunauthorized\\b"}]
+type Rule struct {
+	Tag string // The tag emitted as a tag VALS part, like "network".
+
+	Module   string // The entry's module, like "ns_server".
+	Level    string // The entry's level, like "ERROR", which is normalized.
+	MinLevel string // The entry's level is at least this level, like "WARN".
+	Contains string // The cleansed entry contains this text.
+	RE       string // The cleansed entry matches this regexp.
+
+	re *regexp.Regexp
+}
+
+// loadRules returns the rules of a JSON rules file, or nil.
+func loadRules(path string) ([]Rule, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	err = json.Unmarshal(b, &rules)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range rules {
+		r := &rules[i]
+		if r.Tag == "" {
+			return nil, fmt.Errorf("rule %d has no tag", i)
+		}
+
+		r.Level = normalizeLevel(r.Level)
+		r.MinLevel = normalizeLevel(r.MinLevel)
+
+		for _, level := range []string{r.Level, r.MinLevel} {
+			if level != "" && levelRank(level) < 0 {
+				return nil, fmt.Errorf("rule %d, tag: %s, unknown level: %s", i, r.Tag, level)
+			}
+		}
+
+		if r.RE != "" {
+			r.re, err = regexp.Compile(r.RE)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d, tag: %s, err: %v", i, r.Tag, err)
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+// matches returns true when an entry meets all of the rule's conditions.
+func (r *Rule) matches(module, level string, buf []byte) bool {
+	return (r.Module == "" || r.Module == module) &&
+		(r.Level == "" || r.Level == level) &&
+		(r.MinLevel == "" || levelRank(level) >= levelRank(r.MinLevel)) &&
+		(r.Contains == "" || bytes.Contains(buf, []byte(r.Contains))) &&
+		(r.re == nil || r.re.Match(buf))
+}
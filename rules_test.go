@@ -0,0 +1,48 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadRulesLevels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mortimint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct{ rules, expErr string }{
+		{`[{"tag": "a", "level": "error", "minLevel": "[warn]"}]`, ""},
+		{`[{"tag": "a", "minLevel": "WARNING"}]`, ""},
+		{`[{"tag": "a", "minLevel": "WRN"}]`, "unknown level: WRN"},
+		{`[{"tag": "a"}, {"tag": "b", "level": "bogus"}]`, "rule 1, tag: b, unknown level: BOGU"},
+	} {
+		path := filepath.Join(dir, "rules.json")
+		if err = ioutil.WriteFile(path, []byte(c.rules), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = loadRules(path)
+		if c.expErr == "" && err != nil {
+			t.Errorf("rules: %s, unexpected err: %v", c.rules, err)
+		}
+		if c.expErr != "" && (err == nil || !strings.Contains(err.Error(), c.expErr)) {
+			t.Errorf("rules: %s, expected err: %s, got: %v", c.rules, c.expErr, err)
+		}
+	}
+}
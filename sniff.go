@@ -0,0 +1,124 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// FormatAutoLines is the number of lines, after a FileMeta's header,
+// that are sniffed by the FormatAuto param.
+var FormatAutoLines = 100
+
+// sniffFileMeta finds the FileMeta of a file whose name isn't in the
+// FileMetas, for the FormatAuto param, by picking the FileMeta whose
+// EntryRE or EntryREs match the most of the file's first lines, and
+// remembers it for the selectFile of the file.
+func (run *Run) sniffFileMeta(dir, dirBase, fname string) {
+	if _, exists := lookupFileMeta(fname); exists {
+		return
+	}
+
+	key := dirBase + "/" + fname
+	if _, exists := run.sniffed[key]; exists {
+		return
+	}
+
+	lines, err := sniffLines(dir+string(os.PathSeparator)+fname, FormatAutoLines+10)
+	if err != nil || len(lines) <= 0 {
+		return
+	}
+
+	names := make([]string, 0, len(FileMetas))
+	for name := range FileMetas {
+		names = append(names, name)
+	}
+	sort.Strings(names) // For a deterministic pick among ties.
+
+	var bestName string
+	var bestRate float64
+
+	for _, name := range names {
+		fmeta := FileMetas[name]
+		if fmeta.Skip || fmeta.Tokenizer != "" ||
+			(fmeta.EntryRE == nil && len(fmeta.EntryREs) <= 0) {
+			continue
+		}
+
+		sample := lines
+		if fmeta.HeaderSize < len(sample) {
+			sample = sample[fmeta.HeaderSize:]
+		}
+		if len(sample) > FormatAutoLines {
+			sample = sample[0:FormatAutoLines]
+		}
+
+		var matches int
+		for _, line := range sample {
+			if fmeta.matchesEntry(line) {
+				matches++
+			}
+		}
+
+		rate := float64(matches) / float64(len(sample))
+		if rate > bestRate {
+			bestName, bestRate = name, rate
+		}
+	}
+
+	if bestName == "" {
+		fmt.Fprintf(os.Stderr, "formatAuto: %s, no FileMeta matched\n", run.anonymize(key))
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "formatAuto: %s, using the FileMeta of: %s,"+
+		" match rate: %.2f\n", run.anonymize(key), bestName, bestRate)
+
+	run.sniffed[key] = FileMetas[bestName]
+}
+
+// sniffLines returns up to the first n lines of a file, which is
+// gunzip'ed when it has a ".gz" suffix.
+func sniffLines(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+
+		r = gz
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, ScannerBufferCapacity)
+
+	var lines []string
+	for len(lines) < n && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	return lines, scanner.Err()
+}
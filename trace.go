@@ -0,0 +1,131 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// From the logs of services that handle the same request, like the
+// query service and the indexer, or a REST API and ns_server...
+//
+//	{"level":"info","request_id":"4f0c1a2e-8d7b","msg":"scan started"}
+//	2016-04-14T16:10:05.262-07:00 [Info] scan done, requestId=4f0c1a2e-8d7b
+//	[ns_server:debug,2016-04-14T16:10:05.301] traceID: "4f0c1a2e-8d7b" done
+const defaultRequestIDFields = "request_id,requestId,req_id,trace_id,traceId,x-request-id"
+
+// parseRequestIDFields parses comma-separated, case-insensitive field
+// names, like "request_id,traceId", into a set of the lowercased names,
+// and into a regexp that finds the value of any of the fields in an
+// entry, as the regexp's "id" named group.
+func parseRequestIDFields(fields string) (map[string]bool, *regexp.Regexp, error) {
+	names := map[string]bool{}
+
+	var alts []string
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			names[strings.ToLower(field)] = true
+			alts = append(alts, regexp.QuoteMeta(field))
+		}
+	}
+
+	re, err := regexp.Compile(`(?i)\b(?:` + strings.Join(alts, "|") +
+		`)["']?\s*[:=]\s*["']?(?P<id>[\w.:-]+)`)
+
+	return names, re, err
+}
+
+// requestID returns the request id of an entry, from the vals that
+// were already parsed from the entry, as for logfmt, or else from the
+// entry's content, or "" when the entry has no request id.
+func (p *fileProcessor) requestID(vals []entryVal, buf []byte) string {
+	for _, v := range vals {
+		if p.run.requestIDNames[strings.ToLower(v.name)] {
+			return v.val
+		}
+	}
+
+	return submatchNamedOrFirst(p.run.requestIDRE, "id", string(buf))
+}
+
+// A tracedEntry holds the emits of an entry that has the TraceID,
+// which are invoked once all the files are processed, in the order
+// of the entries' timestamps, across all files.
+type tracedEntry struct {
+	ts      string
+	dirBase string
+	fname   string
+	offset  int64
+	emits   []func()
+}
+
+// traceEntry starts capturing the emits of the current entry of the
+// file, until the returned func is invoked, which then adds the traced
+// entry to the run, so that a concurrent emitTraced only ever sees the
+// traced entries whose capture is done.
+func (p *fileProcessor) traceEntry(ts string, startOffset int64) func() {
+	p.traced = &tracedEntry{ts: ts, dirBase: p.dirBase, fname: p.fname, offset: startOffset}
+
+	return func() {
+		traced := p.traced
+		p.traced = nil
+
+		p.run.m.Lock()
+		p.run.traced = append(p.run.traced, traced)
+		p.run.m.Unlock()
+
+		// Under memory pressure, the entries traced so far are emitted
+		// early, so the timestamp order only holds within each flush.
+		if p.run.memoryPressure() {
+			p.run.emitTraced()
+		}
+	}
+}
+
+// emitTraced invokes the emits of the traced entries, ordered by their
+// timestamps, where ties are ordered by file and by offset.
+func (run *Run) emitTraced() {
+	run.tracedM.Lock() // Keeps concurrent flushes from interleaving.
+	defer run.tracedM.Unlock()
+
+	run.m.Lock()
+	traced := run.traced
+	run.traced = nil
+	run.m.Unlock()
+
+	sort.Slice(traced, func(i, j int) bool {
+		a, b := traced[i], traced[j]
+		if a.ts != b.ts {
+			return a.ts < b.ts
+		}
+		if a.dirBase != b.dirBase {
+			return a.dirBase < b.dirBase
+		}
+		if a.fname != b.fname {
+			return a.fname < b.fname
+		}
+		return a.offset < b.offset
+	})
+
+	for _, te := range traced {
+		for _, emit := range te.emits {
+			emit()
+		}
+	}
+
+	for _, te := range traced {
+		run.entryFlush(te.dirBase, te.fname)
+	}
+}
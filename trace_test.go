@@ -0,0 +1,79 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTracedNamePaths(t *testing.T) {
+	out := runFixture(t, "-emitParts", "VALS", "-traceID", "r1", "testdata/traced")
+
+	expectLines(t, out,
+		"2016-04-14T16:10:10.000 NOTI traced/memcached.log 12:5 memcached cfg.a.b.c d = INT 1",
+		"2016-04-14T16:10:10.000 NOTI traced/memcached.log 12:5 memcached cfg.a.b.c e = INT 2",
+		"2016-04-14T16:10:10.000 NOTI traced/memcached.log 12:5 memcached cfg.a.b.f g = INT 3",
+		"2016-04-14T16:10:10.000 NOTI traced/memcached.log 12:5 memcached cfg.a.b.f h = INT 4")
+}
+
+// TestTracedMemoryPressure traces the entries of a few files at once,
+// where the tiny maxMemory flushes the traced entries after every
+// entry, concurrently with the other files' captures, which is also
+// meant for go test -race.
+func TestTracedMemoryPressure(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/traced/memcached.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+
+	// The entries are repeated after the 4 header lines.
+	data = []byte(strings.Join(lines[0:4], "") +
+		strings.Repeat(strings.Join(lines[4:], ""), 200))
+
+	tmp, err := ioutil.TempDir("", "mortimint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	args := []string{"-emitParts", "VALS", "-traceID", "r1",
+		"-maxMemory", "1", "-workers", "4"}
+
+	for _, node := range []string{"n1", "n2", "n3", "n4"} {
+		dir := filepath.Join(tmp, node)
+
+		err = os.Mkdir(dir, 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(filepath.Join(dir, "memcached.log"), data, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		args = append(args, dir)
+	}
+
+	out := runFixture(t, args...)
+
+	// Every r1 entry of every file has 4 VALS parts.
+	if n := strings.Count(out, " = INT "); n != 4*200*4 {
+		t.Errorf("expected %d traced parts, got %d", 4*200*4, n)
+	}
+}
@@ -0,0 +1,101 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// tsLayout is the layout of the timestamps that mortimint emits,
+// like "2016-04-19T23:10:31.209".
+const tsLayout = "2006-01-02T15:04:05.000"
+
+// tsNone is the timestamp of an emitted entry whose timestamp is unknown.
+const tsNone = "0000-00-00T00:00:00.000"
+
+// tsFit fits a timestamp to the emitted form, by truncating or by
+// padding its fractional seconds, like ".123456" or ".2".
+func tsFit(ts string) string {
+	if len(ts) > len(tsLayout) {
+		return ts[0:len(tsLayout)]
+	}
+	return ts + "000"[0:len(tsLayout)-len(ts)]
+}
+
+// tsSloppy returns true when the fractional seconds of an entry's
+// timestamp, from the "SSSS" group of the entry regexp's match, don't
+// follow a '.' or have fewer than 3 digits, like "31,209" or "31.2".
+func tsSloppy(re *regexp.Regexp, line string, matchIndex []int) bool {
+	i := re.SubexpIndex("SSSS")
+	if i < 0 || 2*i+1 >= len(matchIndex) || matchIndex[2*i] <= 0 {
+		return false // No fractional seconds, like the slash dates.
+	}
+
+	start, end := matchIndex[2*i], matchIndex[2*i+1]
+
+	return line[start-1] != '.' || end-start < 3
+}
+
+// parseTS parses a timestamp in the emitted form, where the
+// fractional seconds are optional and might be of any width.
+func parseTS(ts string) (time.Time, error) {
+	return time.Parse("2006-01-02T15:04:05", ts)
+}
+
+// From rotated or trimmed logs, whose timestamps have no date...
+//
+//	13:23:05.388 [Info] connected with 1 indexers
+//	13:23:06 WARN slow scan
+var re_time_only = regexp.MustCompile(`^(?P<HH>\d\d):(?P<MM>\d\d):(?P<SS>\d\d)` +
+	`(?:[.,](?P<SSSS>\d+))?\s+(?:(?P<level>\[[A-Za-z]+\]|[A-Z]{4,5})\s+)?`)
+
+// inferDate returns the timestamp of a time-only clock, like
+// "13:23:05", and fractional seconds, like "388", on the date of the
+// file's previous timestamp, or "" when there's no previous timestamp,
+// where a time that's more than an hour earlier than the previous
+// timestamp is taken as a rollover to the next day, so that slightly
+// out of order entries don't roll over.
+func (p *fileProcessor) inferDate(clock, frac string) string {
+	if p.inferTS.IsZero() {
+		return ""
+	}
+
+	t, err := time.Parse("2006-01-02T15:04:05", p.inferTS.Format("2006-01-02T")+clock)
+	if err != nil {
+		return ""
+	}
+
+	if p.inferTS.Sub(t) > time.Hour {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	p.inferTS = t
+
+	return tsFit(t.Format("2006-01-02T15:04:05.") + frac)
+}
+
+// formatRelTS formats a duration as a signed offset, like
+// "+00:01:23.456" or "-12:00:00.000".
+func formatRelTS(d time.Duration) string {
+	sign := "+"
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+
+	ms := int64(d / time.Millisecond)
+
+	return fmt.Sprintf("%s%02d:%02d:%02d.%03d", sign,
+		ms/3600000, (ms/60000)%60, (ms/1000)%60, ms%1000)
+}
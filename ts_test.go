@@ -0,0 +1,46 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTimestampTolerance(t *testing.T) {
+	out := runFixture(t, "-emitParts", "FULL", "testdata/sloppy")
+	expectLines(t, out,
+		"2016-04-14T16:10:09.463 WARN sloppy/memcached.log 12:5 memcached comma curr_items=1",
+		"2016-04-14T16:10:10.400 WARN sloppy/memcached.log 72:6 memcached short curr_items=2",
+		"2016-04-14T16:10:11.463 WARN sloppy/memcached.log 127:7 memcached strict curr_items=3")
+
+	out = runFixture(t, "-timestampTolerance=false", "-emitParts", "FULL",
+		"testdata/sloppy")
+	if strings.Contains(out, "comma") || strings.Contains(out, "short") ||
+		!strings.Contains(out, "strict") {
+		t.Errorf("expected only the strict timestamp's entry, got:\n%s", out)
+	}
+}
+
+func TestInferDateEntryWorkers(t *testing.T) {
+	exp := runFixture(t, "-inferDate", "testdata/inferdate")
+
+	expectLines(t, exp,
+		"2016-04-14T23:59:45.100 WARN inferdate/memcached.log 314:15 memcached late n=9",
+		"2016-04-15T00:00:00.200 WARN inferdate/memcached.log 341:16 memcached early n=0")
+
+	// The dates are inferred from the previous entries, in order.
+	out := runFixture(t, "-inferDate", "-entryWorkers", "4", "testdata/inferdate")
+	if out != exp {
+		t.Errorf("expected the same as without entryWorkers:\n%s\ngot:\n%s", exp, out)
+	}
+}
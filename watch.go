@@ -0,0 +1,138 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// watchDirs polls the local dirs every WatchInterval, processing the
+// files as they appear, where each file is selected as usual via its
+// FileMeta and the filters. It never returns.
+func (run *Run) watchDirs() {
+	seen := map[string]bool{} // Keyed by "dirBase/fname".
+
+	workers := run.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	// Bounds the concurrency, except for followed files, which
+	// would otherwise hold onto a worker forever.
+	tokens := make(chan struct{}, workers)
+
+	for {
+		for _, dir := range run.Dirs {
+			if isURL(dir) || isBundle(dir) {
+				continue
+			}
+
+			fileInfos, err := ioutil.ReadDir(dir)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			dirBase := path.Base(dir)
+
+			for _, fileInfo := range fileInfos {
+				fname := fileInfo.Name()
+				if fileInfo.IsDir() || seen[dirBase+"/"+fname] {
+					continue
+				}
+				seen[dirBase+"/"+fname] = true
+
+				if run.FormatAuto {
+					run.sniffFileMeta(dir, dirBase, fname)
+				}
+
+				fmeta, exists := run.selectFile(dirBase, fname)
+				if !exists || !run.selectFileSize(fileInfo.Size()) {
+					continue
+				}
+
+				fmt.Fprintf(os.Stderr, "watchDir found: %s/%s\n",
+					run.anonymize(dirBase), run.anonymize(fname))
+
+				run.m.Lock()
+				run.addFileSize(dirBase, fname, fileInfo.Size())
+				run.spaces = strings.Repeat(" ", run.maxFNameOutLen+1)
+				if run.fileProgress[dirBase] == nil {
+					run.fileProgress[dirBase] = map[string]int64{}
+				}
+				if run.fileProcessors[dirBase] == nil {
+					run.fileProcessors[dirBase] = map[string]*fileProcessor{}
+				}
+				fp := run.newFileProcessor(dir, dirBase, fname, fmeta)
+				fp.follow = run.Follow
+				run.fileProcessors[dirBase][fname] = fp
+				run.m.Unlock()
+
+				go run.watchProcess(fp, tokens)
+			}
+		}
+
+		time.Sleep(run.WatchInterval)
+	}
+}
+
+// watchProcess processes a file that was found by watchDirs, and
+// then merges the file's dict and re-emits the JSON dictionary.
+func (run *Run) watchProcess(fp *fileProcessor, tokens chan struct{}) {
+	if !fp.follow {
+		tokens <- struct{}{}
+		defer func() { <-tokens }()
+	}
+
+	err := fp.process()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	run.entryFlush(fp.dirBase, fp.fname)
+
+	run.m.Lock()
+	run.addDictLocked(fp.dict, fp.dictFull)
+	run.entriesParsed += fp.entriesParsed
+	run.fileProgress[fp.dirBase][fp.fname] = run.fileSizes[fp.dirBase][fp.fname]
+	run.processEmitDict()
+	run.processEmitCooccur()
+	run.m.Unlock()
+}
+
+// followReader reads a growing file, where reaching the end of the
+// file waits for more data to be appended, instead of returning EOF.
+type followReader struct {
+	r        io.ReadCloser
+	interval time.Duration
+}
+
+func (f *followReader) Read(b []byte) (int, error) {
+	for {
+		n, err := f.r.Read(b)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+
+		time.Sleep(f.interval)
+	}
+}
+
+func (f *followReader) Close() error {
+	return f.r.Close()
+}
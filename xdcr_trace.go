@@ -0,0 +1,144 @@
+//  Copyright (c) 2016 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// From ns_server.xdcr_trace.log, where every line after the header
+// is a JSON object, whose ts is in seconds since the epoch...
+//
+//	{"pid":"<0.10592.0>","type":"vbucketReplicationStart","ts":1460675406.262,"vb":22}
+//	{"pid":"<0.10592.0>","type":"vbucketReplicationEnd","ts":1460675406.318,"vb":22}
+//	{"pid":"<0.10592.0>","type":"vbucketReplicationError","ts":1460675407.001,"vb":23,"error":"timeout"}
+//
+// The pid identifies the trace, the vb identifies a span within the
+// trace, and the type is the phase of the span, like start or end.
+
+// xdcrTraceIds maps the derived VALS names of a trace entry to the
+// fields of the trace JSON object that are the source of the values.
+var xdcrTraceIds = [][2]string{
+	{"trace_id", "pid"},
+	{"span_id", "vb"},
+	{"phase", "type"},
+}
+
+// processXDCRTrace reads the lines of a trace log, where every line
+// is an entry of a single JSON object.
+func (p *fileProcessor) processXDCRTrace(r io.Reader) error {
+	var lineLen int64
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, ScannerBufferCapacity)
+	scanner.Split(scanLinesCounted(&lineLen))
+
+	var currOffset int64
+	var currLine int64
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		startOffset := currOffset
+
+		currOffset += lineLen
+		currLine++
+
+		if currLine <= int64(p.fmeta.HeaderSize) {
+			continue
+		}
+
+		p.processXDCRTraceLine(startOffset, currLine, line)
+
+		if p.run.outputLimited() {
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
+
+// processXDCRTraceLine emits a trace line as an entry, with trace_id,
+// span_id and phase VALS parts, where the line's JSON is also parsed,
+// and where a line that's not a JSON object with a ts is a parse error.
+func (p *fileProcessor) processXDCRTraceLine(startOffset, startLine int64,
+	line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) <= 0 {
+		return
+	}
+
+	lines := []string{string(line)}
+
+	p.explain = p.run.isExplained(startOffset, startLine)
+	if p.explain {
+		defer func() { p.explain = false }()
+	}
+
+	var m map[string]interface{}
+
+	d := json.NewDecoder(bytes.NewReader(line))
+	d.UseNumber()
+	if d.Decode(&m) != nil {
+		reason := "bad json"
+		if json.Valid(line) { // A value that's not an object, like a bare number.
+			reason = "not an object"
+		}
+
+		p.parseError(startOffset, startLine, lines, reason)
+		return
+	}
+
+	ts, ok := jsonTS(m["ts"])
+	if !ok {
+		ts = "" // A bad timestamp.
+	}
+
+	if reason := badEntry(ts, lines); reason != "" {
+		p.parseError(startOffset, startLine, lines, reason)
+		return
+	}
+
+	level := "INFO"
+
+	var vals []entryVal
+
+	for _, id := range xdcrTraceIds {
+		switch v := m[id[1]].(type) {
+		case string:
+			vals = append(vals, entryVal{id[0], "STRING", v})
+
+			if id[0] == "phase" && strings.Contains(strings.ToLower(v), "error") {
+				level = "ERRO"
+			}
+
+		case json.Number:
+			if _, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+				vals = append(vals, entryVal{id[0], "INT", string(v)})
+			} else {
+				vals = append(vals, entryVal{id[0], "STRING", string(v)})
+			}
+		}
+	}
+
+	if p.explain {
+		p.explainf("xdcr trace, vals: %+v", vals)
+	}
+
+	p.processEntryParsed(startOffset, startLine, ts, "xdcr_trace", level,
+		lines, vals)
+}